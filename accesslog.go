@@ -0,0 +1,365 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	AccessLogFormatCommon   = iota //Apache common格式
+	AccessLogFormatCombined        //Apache combined格式，多了Referer和User-Agent
+	AccessLogFormatJSON            //每行一个JSON对象
+)
+
+const (
+	defaultAccessLogBufferSize = 4096
+	defaultAccessLogMaxSize    = 100 << 20
+	defaultAccessLogMaxBackups = 5
+)
+
+type AccessLogEntry struct { //一条访问日志
+	Time       time.Time //开始收到请求的时间
+	RemoteAddr string
+	RemoteIP   string
+	User       string
+	Method     string
+	Path       string
+	Query      string
+	Protocol   string
+	Host       string //请求的Host header
+	Code       string
+	BytesSent  uint64 //body的字节数，不含header
+	Latency    time.Duration
+	Referer    string
+	UserAgent  string
+	RequestID  string
+	TlsVersion string //不是TLS连接时为空
+	TlsCipher  string
+	TlsSNI     string
+}
+
+type AccessLogger interface { //访问日志的输出，Log不应当阻塞
+	Log(entry *AccessLogEntry)
+}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLSv1.0",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+func newAccessLogEntry(request *Request, response *Response, start time.Time) *AccessLogEntry {
+	entry := &AccessLogEntry{
+		Time:       start,
+		RemoteAddr: request.Host,
+		RemoteIP:   request.RemoteIP(),
+		User:       request.User,
+		Method:     request.Method,
+		Path:       request.Path,
+		Query:      request.UrlParameter,
+		Protocol:   request.Protocol,
+		Host:       request.Header.Get("Host"),
+		Code:       response.Code,
+		BytesSent:  request.bytesSent,
+		Latency:    time.Since(start),
+		Referer:    request.Header.Get("Referer"),
+		UserAgent:  request.Header.Get("User-Agent"),
+		RequestID:  request.requestID,
+	}
+	if entry.RequestID == "" { //没有用RequestIDMiddleware，或者在它之前的中间件已经回复了
+		if id := request.Header.Get(RequestIDHeader); ValidRequestID(id) {
+			entry.RequestID = id
+		}
+	}
+	if state, ok := request.TlsConnectionState(); ok {
+		entry.TlsVersion = tlsVersionNames[state.Version]
+		entry.TlsCipher = tls.CipherSuiteName(state.CipherSuite)
+		entry.TlsSNI = state.ServerName
+	}
+	return entry
+}
+
+func (app *AppStruct) SetAccessLogger(logger AccessLogger) { //设置访问日志，为nil时只在enableConsoleLog时输出简单的日志
+	app.accessLogger = logger
+}
+
+func (app *AppStruct) logAccess(request *Request, response *Response, start time.Time) {
+	if app.accessLogger != nil {
+		app.accessLogger.Log(newAccessLogEntry(request, response, start))
+	}
+}
+
+func escapeAccessLogString(value string) string { //和Apache一样转义引号、反斜杠和不可见字符，防止伪造日志行
+	if value == "" {
+		return "-"
+	}
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			builder.WriteString("\\x")
+			builder.WriteString(strconv.FormatUint(uint64(c)>>4, 16))
+			builder.WriteString(strconv.FormatUint(uint64(c)&0xf, 16))
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+type jsonAccessLogEntry struct {
+	Time       string  `json:"time"`
+	RemoteIP   string  `json:"remote_ip,omitempty"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Query      string  `json:"query,omitempty"`
+	Protocol   string  `json:"protocol"`
+	Host       string  `json:"host,omitempty"`
+	Status     int     `json:"status"`
+	BytesSent  uint64  `json:"bytes_sent"`
+	LatencyMs  float64 `json:"latency_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	RequestID  string  `json:"request_id,omitempty"`
+	TlsVersion string  `json:"tls_version,omitempty"`
+	TlsCipher  string  `json:"tls_cipher,omitempty"`
+	TlsSNI     string  `json:"tls_sni,omitempty"`
+}
+
+func FormatAccessLog(entry *AccessLogEntry, format int) []byte { //把一条访问日志格式化成一行（含换行）
+	if format == AccessLogFormatJSON {
+		status, _ := strconv.Atoi(entry.Code)
+		line, _ := json.Marshal(jsonAccessLogEntry{entry.Time.Format(time.RFC3339Nano), entry.RemoteIP, entry.RemoteAddr, entry.User, entry.Method, entry.Path, entry.Query, entry.Protocol, entry.Host, status, entry.BytesSent, float64(entry.Latency.Microseconds()) / 1000, entry.Referer, entry.UserAgent, entry.RequestID, entry.TlsVersion, entry.TlsCipher, entry.TlsSNI})
+		return append(line, '\n')
+	}
+
+	var builder strings.Builder
+	remote := entry.RemoteIP
+	if remote == "" {
+		remote = entry.RemoteAddr
+	}
+	builder.WriteString(escapeAccessLogString(remote))
+	builder.WriteString(" - ")
+	builder.WriteString(escapeAccessLogString(entry.User))
+	builder.WriteString(" [")
+	builder.WriteString(entry.Time.Format("02/Jan/2006:15:04:05 -0700"))
+	builder.WriteString("] \"")
+	target := entry.Path
+	if entry.Query != "" {
+		target += "?" + entry.Query
+	}
+	builder.WriteString(escapeAccessLogString(entry.Method + " " + target + " " + entry.Protocol))
+	builder.WriteString("\" ")
+	builder.WriteString(entry.Code)
+	builder.WriteByte(' ')
+	if entry.BytesSent == 0 {
+		builder.WriteByte('-')
+	} else {
+		builder.WriteString(strconv.FormatUint(entry.BytesSent, 10))
+	}
+	if format == AccessLogFormatCombined {
+		builder.WriteString(" \"")
+		builder.WriteString(escapeAccessLogString(entry.Referer))
+		builder.WriteString("\" \"")
+		builder.WriteString(escapeAccessLogString(entry.UserAgent))
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('\n')
+	return []byte(builder.String())
+}
+
+type AccessLogWriter struct { //在后台goroutine里把访问日志写到writer，队列满时丢掉日志而不是阻塞请求
+	writer  io.Writer
+	format  int
+	entries chan *AccessLogEntry
+	lock    sync.RWMutex
+	closed  bool
+	dropped uint64
+	done    chan struct{}
+}
+
+func NewAccessLogWriter(writer io.Writer, format int, bufferSize int) *AccessLogWriter { //创建异步的访问日志，bufferSize为队列长度，0为默认4096
+	if bufferSize <= 0 {
+		bufferSize = defaultAccessLogBufferSize
+	}
+	logWriter := &AccessLogWriter{writer: writer, format: format, entries: make(chan *AccessLogEntry, bufferSize), done: make(chan struct{})}
+	go logWriter.writeLoop()
+	return logWriter
+}
+
+func (logWriter *AccessLogWriter) writeLoop() {
+	defer close(logWriter.done)
+	buffered := bufio.NewWriter(logWriter.writer)
+	for entry := range logWriter.entries {
+		line := FormatAccessLog(entry, logWriter.format)
+		if buffered.Buffered() > 0 && buffered.Available() < len(line) { //缓冲区放不下就先写出去，不让bufio把一行拆成两次写，轮转时行才完整
+			if err := buffered.Flush(); err != nil {
+				log.Println("Access log write error: " + err.Error())
+				buffered.Reset(logWriter.writer)
+			}
+		}
+		buffered.Write(line)
+		if len(logWriter.entries) == 0 { //队列空了再写出去，忙的时候攒在一起写
+			if err := buffered.Flush(); err != nil {
+				log.Println("Access log write error: " + err.Error())
+				buffered.Reset(logWriter.writer)
+			}
+		}
+	}
+	buffered.Flush()
+}
+
+func (logWriter *AccessLogWriter) Log(entry *AccessLogEntry) {
+	logWriter.lock.RLock()
+	defer logWriter.lock.RUnlock()
+	if logWriter.closed {
+		return
+	}
+	select {
+	case logWriter.entries <- entry:
+	default:
+		atomic.AddUint64(&logWriter.dropped, 1)
+	}
+}
+
+func (logWriter *AccessLogWriter) Dropped() uint64 { //队列满了被丢掉的日志条数
+	return atomic.LoadUint64(&logWriter.dropped)
+}
+
+func (logWriter *AccessLogWriter) Close() error { //写完队列里的日志，writer是io.Closer的话也会关掉
+	logWriter.lock.Lock()
+	if logWriter.closed {
+		logWriter.lock.Unlock()
+		return nil
+	}
+	logWriter.closed = true
+	close(logWriter.entries)
+	logWriter.lock.Unlock()
+	<-logWriter.done
+	if closer, ok := logWriter.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type RotatingFile struct { //超过maxSize时把文件改名为path.1、path.2……，最多保留maxBackups个
+	lock       sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) { //打开日志文件，maxSize小于等于0为不轮转
+	rotatingFile := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rotatingFile.open(); err != nil {
+		return nil, err
+	}
+	return rotatingFile, nil
+}
+
+func (rotatingFile *RotatingFile) open() error {
+	file, err := os.OpenFile(rotatingFile.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rotatingFile.file = file
+	rotatingFile.size = stat.Size()
+	return nil
+}
+
+func (rotatingFile *RotatingFile) rotate() error {
+	rotatingFile.file.Close()
+	if rotatingFile.maxBackups <= 0 {
+		os.Remove(rotatingFile.path)
+	} else {
+		os.Remove(rotatingFile.path + "." + strconv.Itoa(rotatingFile.maxBackups))
+		for i := rotatingFile.maxBackups - 1; i >= 1; i-- {
+			os.Rename(rotatingFile.path+"."+strconv.Itoa(i), rotatingFile.path+"."+strconv.Itoa(i+1))
+		}
+		os.Rename(rotatingFile.path, rotatingFile.path+".1")
+	}
+	return rotatingFile.open()
+}
+
+func (rotatingFile *RotatingFile) Write(data []byte) (int, error) {
+	rotatingFile.lock.Lock()
+	defer rotatingFile.lock.Unlock()
+	if rotatingFile.file == nil {
+		return 0, os.ErrClosed
+	}
+	written := 0
+	for rotatingFile.maxSize > 0 && len(data) > 0 && rotatingFile.size+int64(len(data)) > rotatingFile.maxSize { //一次写入可能有很多行，按行切开，写满了就轮转
+		cut := 0
+		if rotatingFile.size < rotatingFile.maxSize {
+			cut = bytes.LastIndexByte(data[:rotatingFile.maxSize-rotatingFile.size], '\n') + 1
+		}
+		if cut == 0 {
+			if rotatingFile.size > 0 {
+				if err := rotatingFile.rotate(); err != nil {
+					rotatingFile.file = nil
+					return written, err
+				}
+				continue
+			}
+			cut = bytes.IndexByte(data, '\n') + 1 //一行就超过maxSize时单独放一个文件
+			if cut == 0 {
+				cut = len(data)
+			}
+		}
+		n, err := rotatingFile.file.Write(data[:cut])
+		rotatingFile.size += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		data = data[cut:]
+	}
+	n, err := rotatingFile.file.Write(data)
+	rotatingFile.size += int64(n)
+	return written + n, err
+}
+
+func (rotatingFile *RotatingFile) Reopen() error { //外部工具（如logrotate）移走文件后重新打开
+	rotatingFile.lock.Lock()
+	defer rotatingFile.lock.Unlock()
+	if rotatingFile.file != nil {
+		rotatingFile.file.Close()
+		rotatingFile.file = nil
+	}
+	return rotatingFile.open()
+}
+
+func (rotatingFile *RotatingFile) Close() error {
+	rotatingFile.lock.Lock()
+	defer rotatingFile.lock.Unlock()
+	if rotatingFile.file == nil {
+		return nil
+	}
+	err := rotatingFile.file.Close()
+	rotatingFile.file = nil
+	return err
+}
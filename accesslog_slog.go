@@ -0,0 +1,55 @@
+//go:build go1.21
+// +build go1.21
+
+package simpwebserv
+
+import (
+	"context"
+	"log/slog"
+)
+
+type SlogAccessLogger struct { //把访问日志输出到log/slog，异步和写文件由slog的Handler决定
+	logger *slog.Logger
+	level  slog.Level
+}
+
+func NewSlogAccessLogger(logger *slog.Logger) *SlogAccessLogger { //logger为nil时使用slog.Default()
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogAccessLogger{logger, slog.LevelInfo}
+}
+
+func (slogLogger *SlogAccessLogger) SetLevel(level slog.Level) { //设置日志级别，默认为Info
+	slogLogger.level = level
+}
+
+func (slogLogger *SlogAccessLogger) Log(entry *AccessLogEntry) {
+	attrs := []slog.Attr{
+		slog.Time("start", entry.Time),
+		slog.String("remote_addr", entry.RemoteAddr),
+		slog.String("method", entry.Method),
+		slog.String("path", entry.Path),
+		slog.String("protocol", entry.Protocol),
+		slog.String("status", entry.Code),
+		slog.Uint64("bytes_sent", entry.BytesSent),
+		slog.Duration("latency", entry.Latency),
+	}
+	optional := [][2]string{
+		{"user", entry.User},
+		{"query", entry.Query},
+		{"host", entry.Host},
+		{"referer", entry.Referer},
+		{"user_agent", entry.UserAgent},
+		{"request_id", entry.RequestID},
+		{"tls_version", entry.TlsVersion},
+		{"tls_cipher", entry.TlsCipher},
+		{"tls_sni", entry.TlsSNI},
+	}
+	for i := 0; i < len(optional); i++ {
+		if optional[i][1] != "" {
+			attrs = append(attrs, slog.String(optional[i][0], optional[i][1]))
+		}
+	}
+	slogLogger.logger.LogAttrs(context.Background(), slogLogger.level, "access", attrs...)
+}
@@ -0,0 +1,145 @@
+package simpwebserv
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAccessLogEntry(path string) *AccessLogEntry {
+	return &AccessLogEntry{Time: time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC), RemoteAddr: "10.0.0.1:5000", RemoteIP: "10.0.0.1", Method: "GET", Path: path, Protocol: "HTTP/1.1", Code: "200", BytesSent: 12}
+}
+
+func TestFormatAccessLog(t *testing.T) {
+	entry := newTestAccessLogEntry("/a\"b")
+	entry.Query = "x=1"
+	entry.UserAgent = "curl\n127.0.0.1 - - fake"
+	if line := string(FormatAccessLog(entry, AccessLogFormatCommon)); line != "10.0.0.1 - - [14/Nov/2023:22:13:20 +0000] \"GET /a\\\"b?x=1 HTTP/1.1\" 200 12\n" {
+		t.Fatalf("common: got %q", line)
+	}
+	if line := string(FormatAccessLog(entry, AccessLogFormatCombined)); !strings.HasSuffix(line, " 200 12 \"-\" \"curl\\x0a127.0.0.1 - - fake\"\n") {
+		t.Fatalf("combined: got %q, the user agent must not start a new line", line)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(FormatAccessLog(entry, AccessLogFormatJSON), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["path"] != "/a\"b" || decoded["status"] != float64(200) || decoded["user_agent"] != entry.UserAgent {
+		t.Fatalf("json: got %v", decoded)
+	}
+}
+
+func TestAccessLogWriterRotation(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	lineLength := len(FormatAccessLog(newTestAccessLogEntry("/00"), AccessLogFormatCommon))
+	file, err := OpenRotatingFile(logPath, int64(lineLength*3), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logWriter := NewAccessLogWriter(file, AccessLogFormatCommon, 0)
+	for i := 10; i < 30; i++ {
+		logWriter.Log(newTestAccessLogEntry("/" + strconv.Itoa(i)))
+	}
+	if err := logWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	logWriter.Log(newTestAccessLogEntry("/closed")) //关掉之后的日志直接丢掉，不能panic
+	if logWriter.Dropped() != 0 {
+		t.Fatalf("dropped %d entries with an empty queue", logWriter.Dropped())
+	}
+
+	wantLast := map[string]string{logPath: "/29", logPath + ".1": "/27", logPath + ".2": "/24"}
+	for name, last := range wantLast {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(data) > lineLength*3 || !strings.Contains(lines[len(lines)-1], "GET "+last+" ") {
+			t.Fatalf("%s: got %q, want at most 3 whole lines ending with %s", name, data, last)
+		}
+	}
+	if _, err := os.Stat(logPath + ".3"); !os.IsNotExist(err) {
+		t.Fatal("only maxBackups old files should be kept")
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	file, err := OpenRotatingFile(logPath, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.Write([]byte("old\n"))
+	if err := os.Rename(logPath, logPath+".moved"); err != nil { //模拟logrotate把文件移走
+		t.Fatal(err)
+	}
+	if err := file.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("new\n"))
+	if data, _ := ioutil.ReadFile(logPath); string(data) != "new\n" {
+		t.Fatalf("got %q in the reopened file", data)
+	}
+	if data, _ := ioutil.ReadFile(logPath + ".moved"); string(data) != "old\n" {
+		t.Fatalf("got %q in the moved file", data)
+	}
+}
+
+type blockingTestWriter struct {
+	release chan struct{}
+	lines   int
+}
+
+func (writer *blockingTestWriter) Write(data []byte) (int, error) {
+	<-writer.release
+	writer.lines += strings.Count(string(data), "\n")
+	return len(data), nil
+}
+
+func TestAccessLogWriterDropsWhenFull(t *testing.T) {
+	writer := &blockingTestWriter{release: make(chan struct{})}
+	logWriter := NewAccessLogWriter(writer, AccessLogFormatCommon, 2)
+	done := make(chan struct{})
+	go func() { //写日志的一边卡住时Log也不能阻塞
+		for i := 0; i < 100; i++ {
+			logWriter.Log(newTestAccessLogEntry("/" + strconv.Itoa(i)))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Log blocked on a stuck writer")
+	}
+	close(writer.release)
+	logWriter.Close()
+	if dropped := logWriter.Dropped(); dropped == 0 || int(dropped)+writer.lines != 100 {
+		t.Fatalf("dropped %d and wrote %d, want them to add up to 100", dropped, writer.lines)
+	}
+}
+
+func TestRotatingFileKeepsLinesWhole(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	file, err := OpenRotatingFile(logPath, 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data := "aaaa\nbbbb\ncccc\n" + strings.Repeat("x", 15) + "\ndd\n"
+	if n, err := file.Write([]byte(data)); err != nil || n != len(data) {
+		t.Fatalf("wrote %d, %v", n, err)
+	}
+	want := map[string]string{logPath + ".3": "aaaa\nbbbb\n", logPath + ".2": "cccc\n", logPath + ".1": strings.Repeat("x", 15) + "\n", logPath: "dd\n"}
+	for name, content := range want {
+		if got, _ := ioutil.ReadFile(name); string(got) != content {
+			t.Fatalf("%s: got %q, want %q", name, got, content)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package simpwebserv
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	LetsEncryptDirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+	acmeChallengePathPrefix = "/.well-known/acme-challenge/"
+	defaultAcmeCacheDir     = "acme-cache"
+	acmeRenewBefore         = 30 * 24 * time.Hour
+	acmeCheckInterval       = 12 * time.Hour
+	acmeMinRetryInterval    = time.Minute
+	acmeMaxRetryInterval    = time.Hour
+)
+
+type acmeManager struct { //用ACME自动申请和续期证书
+	client     *acme.Client
+	domains    []string
+	email      string
+	cacheDir   string
+	challenges []string
+	certStore  *tlsCertStore
+	logEnabled bool
+	lock       sync.RWMutex
+	httpTokens map[string]string           //HTTP-01的token到key authorization
+	alpnCerts  map[string]*tls.Certificate //TLS-ALPN-01的域名到验证证书
+	notAfter   time.Time
+}
+
+func newAcmeManager(config Config, certStore *tlsCertStore, logEnabled bool) (*acmeManager, error) {
+	manager := &acmeManager{domains: config.AcmeDomains, email: config.AcmeEmail, cacheDir: config.AcmeCacheDir, challenges: config.AcmeChallenges, certStore: certStore, logEnabled: logEnabled, httpTokens: make(map[string]string), alpnCerts: make(map[string]*tls.Certificate)}
+	if manager.cacheDir == "" {
+		manager.cacheDir = defaultAcmeCacheDir
+	}
+	if len(manager.challenges) == 0 {
+		manager.challenges = []string{"tls-alpn-01", "http-01"}
+	}
+	if err := os.MkdirAll(manager.cacheDir, 0700); err != nil {
+		return nil, err
+	}
+	accountKey, err := manager.loadOrCreateKey(filepath.Join(manager.cacheDir, "account.key"))
+	if err != nil {
+		return nil, err
+	}
+	directoryURL := config.AcmeDirectoryURL
+	if directoryURL == "" {
+		directoryURL = LetsEncryptDirectoryURL
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if config.AcmeCACertPath != "" { //测试用的ACME服务器（如Pebble）一般是自签名的
+		pool, err := loadCertPool(config.AcmeCACertPath)
+		if err != nil {
+			return nil, err
+		}
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+	manager.client = &acme.Client{Key: accountKey, DirectoryURL: directoryURL, HTTPClient: httpClient}
+	manager.loadCachedCertificate()
+	return manager, nil
+}
+
+func (manager *acmeManager) logPrintln(message string) {
+	if manager.logEnabled {
+		log.Println(message)
+	}
+}
+
+func (manager *acmeManager) loadOrCreateKey(path string) (crypto.Signer, error) { //读取或生成ECDSA P-256私钥
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, ErrNoCertificateFound
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func (manager *acmeManager) certPaths() (string, string) {
+	name := strings.Replace(manager.domains[0], "*", "_", -1)
+	return filepath.Join(manager.cacheDir, name+".crt"), filepath.Join(manager.cacheDir, name+".key")
+}
+
+func (manager *acmeManager) loadCachedCertificate() { //读取磁盘上缓存的证书
+	pemPath, keyPath := manager.certPaths()
+	cert, err := tls.LoadX509KeyPair(pemPath, keyPath)
+	if err != nil {
+		return
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(manager.domains); i++ {
+		if leaf.VerifyHostname(manager.domains[i]) != nil { //域名列表变了就重新申请
+			return
+		}
+	}
+	cert.Leaf = leaf
+	manager.notAfter = leaf.NotAfter
+	manager.certStore.setCertificate(&cert)
+}
+
+func (manager *acmeManager) httpChallengeResponse(request *Request) (*Response, bool) { //回复HTTP-01验证请求
+	if !strings.HasPrefix(request.Path, acmeChallengePathPrefix) {
+		return nil, false
+	}
+	manager.lock.RLock()
+	keyAuth, ok := manager.httpTokens[strings.TrimPrefix(request.Path, acmeChallengePathPrefix)]
+	manager.lock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	response := BuildBasicResponse()
+	response.Header.Set("Content-Type", "text/plain")
+	response.Body.WriteString(keyAuth)
+	return response, true
+}
+
+func (manager *acmeManager) alpnCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, bool) { //TLS-ALPN-01验证时返回验证证书
+	for i := 0; i < len(hello.SupportedProtos); i++ {
+		if hello.SupportedProtos[i] == acme.ALPNProto {
+			manager.lock.RLock()
+			cert, ok := manager.alpnCerts[strings.ToLower(hello.ServerName)]
+			manager.lock.RUnlock()
+			return cert, ok
+		}
+	}
+	return nil, false
+}
+
+func (manager *acmeManager) needsRenewal(now time.Time) bool { //没有证书或者离过期不到30天
+	return manager.notAfter.Sub(now) < acmeRenewBefore
+}
+
+func (manager *acmeManager) run() { //后台检查证书，快过期时续期，失败时逐渐拉长重试间隔
+	retryInterval := acmeMinRetryInterval
+	for {
+		wait := acmeCheckInterval
+		if manager.needsRenewal(time.Now()) {
+			if err := manager.obtain(); err != nil {
+				manager.logPrintln("ACME certificate error: " + err.Error())
+				wait = retryInterval
+				retryInterval *= 2
+				if retryInterval > acmeMaxRetryInterval {
+					retryInterval = acmeMaxRetryInterval
+				}
+			} else {
+				manager.logPrintln("ACME certificate obtained for " + strings.Join(manager.domains, ", "))
+				retryInterval = acmeMinRetryInterval
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (manager *acmeManager) obtain() error { //申请一张包含所有域名的证书
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	account := &acme.Account{}
+	if manager.email != "" {
+		account.Contact = []string{"mailto:" + manager.email}
+	}
+	if _, err := manager.client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return err
+	}
+	order, err := manager.client.AuthorizeOrder(ctx, acme.DomainIDs(manager.domains...))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(order.AuthzURLs); i++ {
+		if err = manager.authorize(ctx, order.AuthzURLs[i]); err != nil {
+			return err
+		}
+	}
+	orderURL := order.URI //WaitOrder返回的订单里可能没有地址，先存下来
+	order, err = manager.client.WaitOrder(ctx, orderURL)
+	if err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: manager.domains[0]}, DNSNames: manager.domains}, key)
+	if err != nil {
+		return err
+	}
+	der, _, err := manager.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil { //有的服务器异步签发且finalize的回复里没有订单地址，这时自己轮询订单再下载证书
+		if order, err = manager.client.WaitOrder(ctx, orderURL); err != nil {
+			return err
+		}
+		if order.Status != acme.StatusValid || order.CertURL == "" {
+			return &acme.OrderError{OrderURL: orderURL, Status: order.Status}
+		}
+		if der, err = manager.client.FetchCert(ctx, order.CertURL, true); err != nil {
+			return err
+		}
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return err
+	}
+
+	var certPem []byte
+	for i := 0; i < len(der); i++ {
+		certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der[i]})...)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	pemPath, keyPath := manager.certPaths()
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	if err = os.WriteFile(pemPath, certPem, 0644); err != nil {
+		return err
+	}
+	manager.notAfter = leaf.NotAfter
+	manager.certStore.setCertificate(&tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf})
+	return nil
+}
+
+func (manager *acmeManager) authorize(ctx context.Context, authzURL string) error { //完成单个域名的验证
+	authz, err := manager.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var challenge *acme.Challenge
+	for i := 0; i < len(manager.challenges) && challenge == nil; i++ {
+		for j := 0; j < len(authz.Challenges); j++ {
+			if authz.Challenges[j].Type == manager.challenges[i] {
+				challenge = authz.Challenges[j]
+				break
+			}
+		}
+	}
+	if challenge == nil {
+		return ErrAcmeNoChallenge
+	}
+	domain := strings.ToLower(authz.Identifier.Value)
+	switch challenge.Type {
+	case "http-01":
+		keyAuth, err := manager.client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return err
+		}
+		manager.lock.Lock()
+		manager.httpTokens[challenge.Token] = keyAuth
+		manager.lock.Unlock()
+		defer func() {
+			manager.lock.Lock()
+			delete(manager.httpTokens, challenge.Token)
+			manager.lock.Unlock()
+		}()
+	case "tls-alpn-01":
+		cert, err := manager.client.TLSALPN01ChallengeCert(challenge.Token, domain)
+		if err != nil {
+			return err
+		}
+		manager.lock.Lock()
+		manager.alpnCerts[domain] = &cert
+		manager.lock.Unlock()
+		defer func() {
+			manager.lock.Lock()
+			delete(manager.alpnCerts, domain)
+			manager.lock.Unlock()
+		}()
+	}
+	if _, err = manager.client.Accept(ctx, challenge); err != nil {
+		return err
+	}
+	_, err = manager.client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+func (app *AppStruct) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) { //先处理ACME的TLS-ALPN-01验证，再按SNI从证书库里选
+	if app.acmeManager != nil {
+		if cert, ok := app.acmeManager.alpnCertificate(hello); ok {
+			return cert, nil
+		}
+	}
+	return app.tlsCertStore.GetCertificate(hello)
+}
@@ -0,0 +1,326 @@
+package simpwebserv
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+var acmeIdentifierOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31} //TLS-ALPN-01验证证书里的扩展
+
+type fakeAcmeAuthz struct {
+	domain string
+	token  string
+	status string
+	order  string
+}
+
+type fakeAcmeOrder struct {
+	domains []string
+	authzs  []string
+	status  string
+	cert    []byte
+}
+
+type fakeAcmeServer struct { //只实现RFC 8555里申请证书用到的部分，不检查JWS签名
+	server     *httptest.Server
+	caCert     *x509.Certificate
+	caKey      *ecdsa.PrivateKey
+	validate   func(challengeType string, token string, domain string) bool
+	lock       sync.Mutex
+	validity   time.Duration
+	registered bool
+	requests   map[string]int //按请求类型计数
+	validated  []string
+	authzs     map[string]*fakeAcmeAuthz
+	orders     map[string]*fakeAcmeOrder
+	next       int
+}
+
+func newFakeAcmeServer(t *testing.T) *fakeAcmeServer {
+	caCert, caKey := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Fake ACME CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	fake := &fakeAcmeServer{caCert: caCert, caKey: caKey, validity: 90 * 24 * time.Hour, requests: make(map[string]int), authzs: make(map[string]*fakeAcmeAuthz), orders: make(map[string]*fakeAcmeOrder)}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (fake *fakeAcmeServer) count(kind string) int {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return fake.requests[kind]
+}
+
+func (fake *fakeAcmeServer) orderJSON(w http.ResponseWriter, id string, code int) {
+	order := fake.orders[id]
+	body := map[string]interface{}{"status": order.status, "authorizations": order.authzs, "finalize": fake.server.URL + "/finalize/" + id}
+	if order.cert != nil {
+		body["certificate"] = fake.server.URL + "/cert/" + id
+	}
+	w.Header().Set("Location", fake.server.URL+"/order/"+id)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (fake *fakeAcmeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", generateRandomString(16))
+	w.Header().Set("Content-Type", "application/json")
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	json.NewDecoder(r.Body).Decode(&jws)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.requests[parts[0]]++
+	switch parts[0] {
+	case "directory":
+		url := fake.server.URL
+		json.NewEncoder(w).Encode(map[string]interface{}{"newNonce": url + "/new-nonce", "newAccount": url + "/new-account", "newOrder": url + "/new-order", "revokeCert": url + "/revoke", "keyChange": url + "/key-change", "meta": map[string]string{"termsOfService": url + "/terms"}})
+	case "new-nonce":
+	case "new-account":
+		w.Header().Set("Location", fake.server.URL+"/account/1")
+		if fake.registered {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			fake.registered = true
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(`{"status":"valid"}`))
+	case "new-order":
+		var request struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &request)
+		fake.next++
+		id := strconv.Itoa(fake.next)
+		order := &fakeAcmeOrder{status: acme.StatusPending}
+		for i, identifier := range request.Identifiers {
+			authzID := id + "-" + strconv.Itoa(i)
+			fake.authzs[authzID] = &fakeAcmeAuthz{identifier.Value, generateRandomString(16), acme.StatusPending, id}
+			order.domains = append(order.domains, identifier.Value)
+			order.authzs = append(order.authzs, fake.server.URL+"/authz/"+authzID)
+		}
+		fake.orders[id] = order
+		fake.orderJSON(w, id, http.StatusCreated)
+	case "authz":
+		authz := fake.authzs[parts[1]]
+		var challenges []map[string]string
+		for _, challengeType := range []string{"http-01", "tls-alpn-01"} {
+			challenges = append(challenges, map[string]string{"type": challengeType, "url": fake.server.URL + "/challenge/" + parts[1] + "/" + challengeType, "token": authz.token, "status": authz.status})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": authz.status, "identifier": map[string]string{"type": "dns", "value": authz.domain}, "challenges": challenges})
+	case "challenge":
+		authz := fake.authzs[parts[1]]
+		fake.validated = append(fake.validated, parts[2])
+		authz.status = acme.StatusInvalid
+		if fake.validate(parts[2], authz.token, authz.domain) {
+			authz.status = acme.StatusValid
+		}
+		order := fake.orders[authz.order]
+		order.status = acme.StatusReady
+		for _, url := range order.authzs {
+			if fake.authzs[url[strings.LastIndexByte(url, '/')+1:]].status != acme.StatusValid {
+				order.status = acme.StatusPending
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"type": parts[2], "url": fake.server.URL + r.URL.Path, "token": authz.token, "status": authz.status})
+	case "order":
+		fake.orderJSON(w, parts[1], http.StatusOK)
+	case "finalize":
+		order := fake.orders[parts[1]]
+		var request struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &request)
+		der, _ := base64.RawURLEncoding.DecodeString(request.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if order.status != acme.StatusReady || err != nil || strings.Join(csr.DNSNames, ",") != strings.Join(order.domains, ",") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"type":"urn:ietf:params:acme:error:orderNotReady"}`))
+			return
+		}
+		template := &x509.Certificate{SerialNumber: big.NewInt(int64(fake.next*1000 + fake.requests["finalize"])), Subject: pkix.Name{CommonName: csr.Subject.CommonName}, DNSNames: csr.DNSNames, NotBefore: time.Now().Add(-time.Minute), NotAfter: time.Now().Add(fake.validity), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+		leaf, _ := x509.CreateCertificate(rand.Reader, template, fake.caCert, csr.PublicKey, fake.caKey)
+		order.cert = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fake.caCert.Raw})...)
+		order.status = acme.StatusValid
+		fake.orderJSON(w, parts[1], http.StatusOK)
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(fake.orders[parts[1]].cert)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestAcmeManager(t *testing.T, fake *fakeAcmeServer, cacheDir string, domains []string, challenges []string) (*acmeManager, *tlsCertStore) {
+	store := newTlsCertStore()
+	manager, err := newAcmeManager(Config{AcmeDomains: domains, AcmeEmail: "admin@example.com", AcmeCacheDir: cacheDir, AcmeDirectoryURL: fake.server.URL + "/directory", AcmeChallenges: challenges}, store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.lock.Lock()
+	fake.validate = func(challengeType string, token string, domain string) bool { //像真正的CA一样去取验证内容
+		switch challengeType {
+		case "http-01":
+			response, ok := manager.httpChallengeResponse(newTestRequest(t, "GET", acmeChallengePathPrefix+token))
+			want, _ := manager.client.HTTP01ChallengeResponse(token)
+			return ok && response.Body.String() == want
+		case "tls-alpn-01":
+			cert, ok := manager.alpnCertificate(&tls.ClientHelloInfo{ServerName: domain, SupportedProtos: []string{acme.ALPNProto}})
+			if !ok {
+				return false
+			}
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil || len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != domain {
+				return false
+			}
+			for _, extension := range leaf.Extensions {
+				if extension.Id.Equal(acmeIdentifierOID) && extension.Critical {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	fake.lock.Unlock()
+	return manager, store
+}
+
+func servedSerial(t *testing.T, store *tlsCertStore, name string) string {
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return cert.Leaf.SerialNumber.String()
+}
+
+func TestAcmeObtainAndRenew(t *testing.T) {
+	fake := newFakeAcmeServer(t)
+	cacheDir := t.TempDir()
+	domains := []string{"example.com", "www.example.com"}
+	manager, store := newTestAcmeManager(t, fake, cacheDir, domains, []string{"http-01"})
+	if !manager.needsRenewal(time.Now()) {
+		t.Fatal("a manager without a certificate should obtain one")
+	}
+
+	if err := manager.obtain(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.count("new-account") != 1 || strings.Join(fake.validated, ",") != "http-01,http-01" {
+		t.Fatalf("got %v requests, validated %v", fake.requests, fake.validated)
+	}
+	first := servedSerial(t, store, "www.example.com")
+	if manager.needsRenewal(time.Now()) || !manager.needsRenewal(time.Now().Add(61*24*time.Hour)) {
+		t.Fatalf("a 90 day certificate should be renewed 30 days before it expires, notAfter %v", manager.notAfter)
+	}
+	if len(manager.httpTokens) != 0 {
+		t.Fatal("challenge tokens should be removed after validation")
+	}
+
+	fake.lock.Lock()
+	fake.validity = 20 * 24 * time.Hour
+	fake.lock.Unlock()
+	if err := manager.obtain(); err != nil { //续期，账号已经存在
+		t.Fatal(err)
+	}
+	renewed := servedSerial(t, store, "example.com")
+	if fake.count("new-account") != 2 || fake.count("new-order") != 2 || renewed == first {
+		t.Fatalf("renewal should register again and replace the certificate, got %v, serial %s -> %s", fake.requests, first, renewed)
+	}
+	if len(store.entries) != 1 || !manager.needsRenewal(time.Now()) {
+		t.Fatal("the renewed certificate should replace the old one")
+	}
+
+	cached, cachedStore := newTestAcmeManager(t, fake, cacheDir, domains, nil)
+	if !cached.notAfter.Equal(manager.notAfter) || servedSerial(t, cachedStore, "www.example.com") != renewed {
+		t.Fatal("a restarted manager should serve the cached certificate")
+	}
+	if fake.count("new-order") != 2 {
+		t.Fatal("loading the cache should not contact the CA")
+	}
+	changed, changedStore := newTestAcmeManager(t, fake, cacheDir, []string{"example.com", "api.example.com"}, nil)
+	if !changed.needsRenewal(time.Now()) || !changedStore.empty() {
+		t.Fatal("a cached certificate missing a configured domain should not be used")
+	}
+}
+
+func TestAcmeTlsAlpnChallenge(t *testing.T) {
+	fake := newFakeAcmeServer(t)
+	manager, store := newTestAcmeManager(t, fake, t.TempDir(), []string{"example.com"}, []string{"tls-alpn-01", "http-01"})
+	if err := manager.obtain(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fake.validated, ",") != "tls-alpn-01" || store.empty() || len(manager.alpnCerts) != 0 {
+		t.Fatalf("validated %v", fake.validated)
+	}
+}
+
+func TestAcmeFailedChallenge(t *testing.T) {
+	fake := newFakeAcmeServer(t)
+	manager, store := newTestAcmeManager(t, fake, t.TempDir(), []string{"example.com"}, []string{"http-01"})
+	fake.lock.Lock()
+	fake.validate = func(string, string, string) bool {
+		return false
+	}
+	fake.lock.Unlock()
+	if err := manager.obtain(); err == nil {
+		t.Fatal("an invalid authorization should fail")
+	}
+	if !store.empty() || fake.count("finalize") != 0 || !manager.needsRenewal(time.Now()) {
+		t.Fatal("nothing should be issued after a failed challenge")
+	}
+}
+
+func TestTlsNextProtos(t *testing.T) {
+	app := App()
+	if protos := app.tlsNextProtos(); len(protos) != 1 || protos[0] != "http/1.1" {
+		t.Errorf("without ACME: got %v", protos)
+	}
+	app.acmeManager = &acmeManager{}
+	if protos := app.tlsNextProtos(); len(protos) != 2 || protos[1] != acme.ALPNProto {
+		t.Errorf("with ACME: got %v", protos)
+	}
+}
+
+func TestAcmeHttpChallenge(t *testing.T) {
+	manager := &acmeManager{httpTokens: map[string]string{"token": "token.thumbprint"}}
+	response, ok := manager.httpChallengeResponse(newTestRequest(t, "GET", acmeChallengePathPrefix+"token"))
+	if !ok || response.Body.String() != "token.thumbprint" || response.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("got %v", ok)
+	}
+	for _, path := range []string{acmeChallengePathPrefix + "other", "/token"} {
+		if _, ok := manager.httpChallengeResponse(newTestRequest(t, "GET", path)); ok {
+			t.Errorf("%s should not be answered", path)
+		}
+	}
+}
+
+func TestAcmeAlpnCertificate(t *testing.T) {
+	cert := &tls.Certificate{}
+	app := App()
+	app.acmeManager = &acmeManager{alpnCerts: map[string]*tls.Certificate{"example.com": cert}}
+	if got, err := app.getCertificate(&tls.ClientHelloInfo{ServerName: "Example.COM", SupportedProtos: []string{acme.ALPNProto}}); err != nil || got != cert {
+		t.Errorf("challenge handshake: got %v, %v", got, err)
+	}
+	if got, _ := app.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com", SupportedProtos: []string{"http/1.1"}}); got == cert {
+		t.Error("the challenge certificate must only be served for acme-tls/1")
+	}
+}
@@ -0,0 +1,91 @@
+package simpwebserv
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	listenFdsStart     = 3 //systemd传过来的第一个文件描述符
+	upgradeParentEnv   = "SIMPWEBSERV_UPGRADE_PPID"
+	upgradeReadyFdEnv  = "SIMPWEBSERV_UPGRADE_READY_FD"
+	redirectListenName = "redirect" //HTTP重定向端口的监听
+	adminListenName    = "admin"    //管理端口的监听
+)
+
+type namedListener struct { //从systemd或者旧进程继承的、或者平滑升级时要交给新进程的监听，name为LISTEN_FDNAMES里的名字
+	listener net.Listener
+	name     string
+}
+
+func (inherited namedListener) useTls(defaultTls bool) bool { //名字为https或http时按名字决定，否则跟随主监听
+	switch inherited.name {
+	case "https":
+		return true
+	case "http":
+		return false
+	}
+	return defaultTls
+}
+
+func inheritListeners() ([]namedListener, *os.File, error) { //读取LISTEN_FDS（systemd socket activation）或者平滑升级时旧进程传过来的监听
+	if pid := os.Getenv("LISTEN_PID"); pid != "" {
+		if pid != strconv.Itoa(os.Getpid()) {
+			return nil, nil, nil
+		}
+	} else if os.Getenv(upgradeParentEnv) != strconv.Itoa(os.Getppid()) {
+		return nil, nil, nil
+	}
+	num, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || num <= 0 {
+		return nil, nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	var readyFile *os.File
+	if fd, err := strconv.Atoi(os.Getenv(upgradeReadyFdEnv)); err == nil && os.Getenv(upgradeParentEnv) != "" {
+		readyFile = os.NewFile(uintptr(fd), "upgrade-ready")
+	}
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", upgradeParentEnv, upgradeReadyFdEnv} { //不再传给子进程
+		os.Unsetenv(key)
+	}
+
+	inheritedList := make([]namedListener, 0, num)
+	for i := 0; i < num; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for j := 0; j < len(inheritedList); j++ {
+				inheritedList[j].listener.Close()
+			}
+			if readyFile != nil {
+				readyFile.Close()
+			}
+			return nil, nil, err
+		}
+		inheritedList = append(inheritedList, namedListener{listener, name})
+	}
+	return inheritedList, readyFile, nil
+}
+
+func notifyUpgradeReady(readyFile *os.File) { //告诉旧进程新进程已经开始监听，旧进程收到后退出
+	if readyFile == nil {
+		return
+	}
+	readyFile.Write([]byte{1})
+	readyFile.Close()
+}
+
+func listenerAddress(listener net.Listener) string { //给日志用的地址
+	addr := listener.Addr()
+	if addr.Network() == "unix" {
+		return "unix:" + addr.String()
+	}
+	return addr.String()
+}
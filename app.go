@@ -1,16 +1,20 @@
 package simpwebserv
 
 import (
+	"context"
 	"crypto/tls"
+	"io"
 	"log"
-	"net"
-	"strconv"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 func App() *AppStruct { //创建一个app实例
-	app := AppStruct{nil, &UrlNode{make(map[string]*UrlNode), false, nil}, false, nil, nil, nil, false, false, false, 4, 60}
+	app := AppStruct{nil, &UrlNode{make(map[string]*UrlNode), false, nil, "/"}, false, nil, nil, nil, false, false, false, 4, defaultIdleTimeout, defaultReadHeaderTimeout, defaultReadTimeout, defaultWriteTimeout, nil, nil, nil, tls.NoClientCert, newTlsCertStore(), defaultTlsReloadInterval, nil, "", nil, sync.Mutex{}, newConnTracker(), defaultShutdownTimeout, nil, nil, newConnLimiter(), 0, defaultMaxHeaderCount, defaultMaxHeaderBytes, nil, nil, nil, nil, nil, newHealthRegistry(), nil}
+	app.baseContext, app.cancelBaseContext = context.WithCancel(context.Background())
+	app.handler = app.dispatch
 	return &app
 }
 
@@ -25,22 +29,24 @@ func (app *AppStruct) Register(function func(*Request) *Response, path string, i
 		if v, ok := nowNode.NextLayer[pathSplit[i]]; ok {
 			nowNode = v
 		} else {
-			nowNode.NextLayer[pathSplit[i]] = &UrlNode{make(map[string]*UrlNode), false, nil}
+			nowNode.NextLayer[pathSplit[i]] = &UrlNode{make(map[string]*UrlNode), false, nil, ""}
 			nowNode = nowNode.NextLayer[pathSplit[i]]
 		}
 	}
 	nowNode.IncludeBack = includeBack
 	nowNode.Function = function
+	nowNode.pattern = "/" + strings.Join(pathSplit, "/")
+	if includeBack {
+		nowNode.pattern = strings.TrimSuffix(nowNode.pattern, "/") + "/*"
+	}
 }
 
-func (app *AppStruct) SetTls(pemPath string, keyPath string) error { //设置TLS
-	cert, err := tls.LoadX509KeyPair(pemPath, keyPath)
-	if err != nil {
+func (app *AppStruct) SetTls(pemPath string, keyPath string) error { //设置TLS（会替换掉之前设置的所有证书）
+	if _, err := tls.LoadX509KeyPair(pemPath, keyPath); err != nil {
 		return err
 	}
-	app.useTls = true
-	app.HTTPSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
-	return nil
+	app.tlsCertStore.clear()
+	return app.AddTlsCertificate(pemPath, keyPath)
 }
 
 func (app *AppStruct) SetDebugMode(onoff bool) { //设置debugMode（就是在出现500时默认会不会在网页上显示堆栈跟踪）
@@ -55,24 +61,105 @@ func (app *AppStruct) SetEnableKeepAlive(onoff bool) { //设置enableKeepAlive
 	app.enableKeepAlive = onoff
 }
 
-func (app *AppStruct) SetKeepAliveTimeout(timeout uint64) { //设置keepAliveTimeout（启用keep-alive的时候的连接超时时间）
-	app.keepAliveTimeout = time.Duration(timeout)
+func (app *AppStruct) SetKeepAliveTimeout(timeout uint64) { //设置keepAliveTimeout（启用keep-alive的时候的连接超时时间，单位为秒）
+	app.idleTimeout = time.Duration(timeout) * time.Second
 }
 
 func (app *AppStruct) loadConfig(config Config) error {
 	app.debugMode = config.DebugMode
 	app.enableConsoleLog = !config.DisableConsoleLog
 	app.enableKeepAlive = !config.DisableKeepAlive
-	if config.KeepAliveTimeout != 0 {
-		app.keepAliveTimeout = config.KeepAliveTimeout
+	if config.KeepAliveTimeout >= time.Second { //已经是Duration（如30*time.Second），再乘会溢出
+		app.idleTimeout = config.KeepAliveTimeout
+	} else if config.KeepAliveTimeout != 0 {
+		app.idleTimeout = config.KeepAliveTimeout * time.Second
+	}
+	app.SetTimeouts(config.ReadHeaderTimeout, config.ReadTimeout, config.WriteTimeout, config.IdleTimeout)
+	if config.TlsReloadInterval != 0 {
+		app.tlsReloadInterval = config.TlsReloadInterval
+	}
+	for i := 0; i < len(config.Listeners); i++ {
+		if config.Listeners[i].UseTls {
+			config.UseTls = true
+		}
 	}
 	if config.UseTls {
-		cert, err := tls.LoadX509KeyPair(config.TlsPemPath, config.TlsKeyPath)
+		if config.TlsPemPath != "" {
+			if err := app.SetTls(config.TlsPemPath, config.TlsKeyPath); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < len(config.TlsCertificates); i++ {
+			if err := app.AddTlsCertificate(config.TlsCertificates[i].PemPath, config.TlsCertificates[i].KeyPath); err != nil {
+				return err
+			}
+		}
+		if config.DevCert {
+			if err := app.SetDevTls(config.DevCertDir, append([]string{config.Host}, config.DevCertHosts...)...); err != nil {
+				return err
+			}
+		}
+		if len(config.AcmeDomains) != 0 {
+			manager, err := newAcmeManager(config, app.tlsCertStore, app.enableConsoleLog)
+			if err != nil {
+				return err
+			}
+			app.acmeManager = manager
+			if app.HTTPSConfig != nil { //前面设置证书时已经生成了TLS配置
+				app.HTTPSConfig.NextProtos = app.tlsNextProtos()
+			}
+		} else if app.tlsCertStore.empty() {
+			return ErrNoCertificateFound
+		}
+		app.useTls = true
+		if app.HTTPSConfig == nil {
+			app.HTTPSConfig = app.newTlsConfig()
+		}
+	}
+	if config.TlsClientCAPath != "" || config.TlsClientAuth != TlsClientAuthNone {
+		if err := app.SetTlsClientAuth(config.TlsClientCAPath, config.TlsClientAuth); err != nil {
+			return err
+		}
+	}
+	if config.HSTSMaxAge > 0 {
+		app.SetHSTS(config.HSTSMaxAge, config.HSTSIncludeSubdomains, config.HSTSPreload)
+	}
+	if config.ShutdownTimeout != 0 {
+		app.shutdownTimeout = config.ShutdownTimeout
+	}
+	app.SetConnectionLimits(config.MaxConnections, config.MaxConnectionsPerIP)
+	app.SetMinTransferRate(config.MinTransferRate)
+	app.SetHeaderLimits(config.MaxHeaderCount, config.MaxHeaderBytes)
+	if config.AccessLogPath != "" {
+		if config.AccessLogMaxSize == 0 {
+			config.AccessLogMaxSize = defaultAccessLogMaxSize
+		}
+		if config.AccessLogMaxBackups == 0 {
+			config.AccessLogMaxBackups = defaultAccessLogMaxBackups
+		}
+		file, err := OpenRotatingFile(config.AccessLogPath, config.AccessLogMaxSize, config.AccessLogMaxBackups)
 		if err != nil {
 			return err
 		}
-		app.useTls = true
-		app.HTTPSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		app.SetAccessLogger(NewAccessLogWriter(file, config.AccessLogFormat, 0))
+	}
+	if config.MetricsPath != "" { //注册到哪个端口要等打开监听后才知道有没有管理端口
+		app.EnableMetrics()
+	}
+	if config.TracingExporter != "" {
+		var exporter SpanExporter
+		if strings.HasPrefix(config.TracingExporter, "http://") || strings.HasPrefix(config.TracingExporter, "https://") {
+			exporter = NewOTLPHTTPExporter(config.TracingExporter, nil)
+		} else if config.TracingExporter == "stdout" {
+			exporter = NewWriterSpanExporter(os.Stdout)
+		} else {
+			file, err := os.OpenFile(config.TracingExporter, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			exporter = NewWriterSpanExporter(file)
+		}
+		app.SetTracer(NewTracer(TracerConfig{ServiceName: config.TracingServiceName, Exporter: exporter, SampleRatio: config.TracingSampleRatio}))
 	}
 	if config.MultiThreadAcceptNum != 0 {
 		app.multiThreadAcceptNum = config.MultiThreadAcceptNum
@@ -81,50 +168,51 @@ func (app *AppStruct) loadConfig(config Config) error {
 }
 
 func (app *AppStruct) Run(config Config) { //运行服务
-	allHost := config.Host + ":" + strconv.Itoa(int(config.Port))
 	err := app.loadConfig(config)
 	if err != nil {
 		panic(err)
 	}
 
-	if app.useTls {
-		app.listener, err = tls.Listen("tcp", allHost, app.HTTPSConfig)
-	} else {
-		app.listener, err = net.Listen("tcp", allHost)
-	}
+	listeners, redirectListener, adminListener, err := app.openListeners(config)
 	if err != nil {
 		log.Fatal("Server listen error: " + err.Error())
 		return
 	}
+	app.listener = listeners[0]
 
-	if app.enableConsoleLog {
-		if app.useTls {
-			if config.Port == 443 {
-				log.Println("Server is starting at: https://" + config.Host)
-			} else {
-				log.Println("Server is starting at: https://" + allHost)
-			}
-		} else {
-			if config.Port == 80 {
-				log.Println("Server is starting at: http://" + config.Host)
-			} else {
-				log.Println("Server is starting at: http://" + allHost)
-			}
-		}
+	if app.useTls && app.tlsCertStore.hasFiles() {
+		go app.watchTlsCertificates(app.tlsReloadInterval)
 	}
-	for i := 0; i < int(app.multiThreadAcceptNum)-1; i++ {
-		go app.acceptConn()
+	if redirectListener != nil {
+		app.redirectApp = app.newRedirectApp(config.Port)
+		go app.redirectApp.Serve(redirectListener)
 	}
-	app.acceptConn()
-}
-
-func (app *AppStruct) acceptConn() {
-	for {
-		conn, err := app.listener.Accept()
-		if err != nil {
-			log.Fatal("Server accept error: " + err.Error())
-			continue
+	if adminListener != nil { //配置了或者继承了管理端口时，健康检查和监控指标只在管理端口提供
+		app.adminApp = app.newAdminApp(config)
+		go app.adminApp.Serve(adminListener)
+	} else {
+		if config.MetricsPath != "" {
+			app.RegisterMetrics(config.MetricsPath)
+		}
+		if config.HealthEndpoints {
+			app.RegisterHealthEndpoints(nil)
 		}
-		go connectionHandler(conn, app)
+	}
+	if app.acmeManager != nil {
+		go app.acmeManager.run()
+	}
+	if config.GracefulRestart {
+		go app.handleRestartSignals()
+	}
+	app.serveAll(listeners)
+	app.connTracker.wait()
+	if app.adminApp != nil { //请求都处理完了再关，编排系统在平滑退出期间还能看到readiness失败
+		app.adminApp.Shutdown(app.shutdownTimeout)
+	}
+	if closer, ok := app.accessLogger.(io.Closer); ok { //把队列里的日志写完
+		closer.Close()
+	}
+	if app.tracer != nil { //把没导出的span导出
+		app.tracer.Close()
 	}
 }
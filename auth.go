@@ -0,0 +1,397 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultAuthRealm          = "Restricted"
+	defaultDigestNonceTimeout = 5 * time.Minute
+	digestNonceDataSize       = 8 + 16 //时间戳和随机数
+)
+
+type CredentialStore interface { //用户凭据的查询接口
+	VerifyPassword(username string, password string) bool                     //Basic认证用
+	DigestHA1(username string, realm string, algorithm string) (string, bool) //Digest认证用，返回H(username:realm:password)
+}
+
+type StaticCredentials map[string]string //用户名到明文密码的映射，Basic和Digest都能用
+
+func (credentials StaticCredentials) VerifyPassword(username string, password string) bool {
+	expected, ok := credentials[username]
+	if !ok {
+		expected = generateRandomString(16) //用户不存在也做一次比较，避免时间差暴露用户是否存在
+	}
+	expectedSum := sha256.Sum256([]byte(expected))
+	passwordSum := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(expectedSum[:], passwordSum[:]) == 1 && ok
+}
+
+func (credentials StaticCredentials) DigestHA1(username string, realm string, algorithm string) (string, bool) {
+	password, ok := credentials[username]
+	if !ok {
+		return "", false
+	}
+	return digestHash(algorithm, username+":"+realm+":"+password), true
+}
+
+type HtpasswdCredentials struct { //htpasswd文件，支持bcrypt和{SHA}，只能用于Basic认证
+	path      string
+	lock      sync.RWMutex
+	users     map[string]string
+	dummyHash string //用户不存在时拿来比较的哈希，和文件里最慢的哈希一样慢
+}
+
+func isBcryptHash(hashed string) bool {
+	return strings.HasPrefix(hashed, "$2y$") || strings.HasPrefix(hashed, "$2a$") || strings.HasPrefix(hashed, "$2b$")
+}
+
+func htpasswdDummyHash(users map[string]string) (string, error) { //按文件里最高的bcrypt cost生成一个随机密码的哈希，没有bcrypt时用{SHA}
+	cost := 0
+	for _, hashed := range users {
+		if isBcryptHash(hashed) {
+			if hashCost, err := bcrypt.Cost([]byte(hashed)); err == nil && hashCost > cost {
+				cost = hashCost
+			}
+		}
+	}
+	if cost == 0 {
+		sum := sha1.Sum(generateRandomBytes(16))
+		return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:]), nil
+	}
+	hashed, err := bcrypt.GenerateFromPassword(generateRandomBytes(16), cost)
+	return string(hashed), err
+}
+
+func LoadHtpasswdFile(path string) (*HtpasswdCredentials, error) { //读取htpasswd文件
+	credentials := &HtpasswdCredentials{path: path}
+	if err := credentials.Reload(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (credentials *HtpasswdCredentials) Reload() error { //重新读取htpasswd文件
+	f, err := os.Open(credentials.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		index := strings.IndexByte(line, ':')
+		if index <= 0 {
+			continue
+		}
+		users[line[:index]] = line[index+1:]
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	dummyHash, err := htpasswdDummyHash(users)
+	if err != nil {
+		return err
+	}
+	credentials.lock.Lock()
+	credentials.users = users
+	credentials.dummyHash = dummyHash
+	credentials.lock.Unlock()
+	return nil
+}
+
+func (credentials *HtpasswdCredentials) VerifyPassword(username string, password string) bool {
+	credentials.lock.RLock()
+	hashed, ok := credentials.users[username]
+	if !ok {
+		hashed = credentials.dummyHash //用户不存在也做一次同样慢的比较，避免时间差暴露用户是否存在
+	}
+	credentials.lock.RUnlock()
+	switch {
+	case isBcryptHash(hashed):
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil && ok
+	case strings.HasPrefix(hashed, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(hashed[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1 && ok
+	}
+	return false
+}
+
+func (credentials *HtpasswdCredentials) DigestHA1(username string, realm string, algorithm string) (string, bool) {
+	return "", false
+}
+
+func (request *Request) BasicAuth() (string, string, bool) { //解析Authorization里的Basic用户名和密码
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) < 6 || !strings.EqualFold(authorization[:6], "Basic ") {
+		return "", "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(authorization[6:]))
+	if err != nil {
+		return "", "", false
+	}
+	index := strings.IndexByte(string(data), ':')
+	if index == -1 {
+		return "", "", false
+	}
+	return string(data[:index]), string(data[index+1:]), true
+}
+
+func buildUnauthorizedResponse(challenges ...string) *Response {
+	response := Build401Response()
+	for i := 0; i < len(challenges); i++ {
+		response.Header.Add("WWW-Authenticate", challenges[i])
+	}
+	return response
+}
+
+func quoteAuthParam(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+type BasicAuthConfig struct { //Basic认证中间件的配置
+	Realm       string
+	Credentials CredentialStore
+}
+
+func BasicAuthMiddleware(config BasicAuthConfig) Middleware { //Basic认证中间件，通过后用request.User拿到用户名
+	if config.Realm == "" {
+		config.Realm = defaultAuthRealm
+	}
+	challenge := "Basic realm=" + quoteAuthParam(config.Realm) + `, charset="UTF-8"`
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			username, password, ok := request.BasicAuth()
+			if !ok || !config.Credentials.VerifyPassword(username, password) {
+				return buildUnauthorizedResponse(challenge)
+			}
+			request.User = username
+			return next(request)
+		}
+	}
+}
+
+var digestHashFuncs = map[string]func() hash.Hash{ //RFC 7616支持的算法，-sess变体用同样的哈希
+	"MD5":         md5.New,
+	"SHA-256":     sha256.New,
+	"SHA-512-256": sha512.New512_256,
+}
+
+func isDigestSessAlgorithm(algorithm string) bool {
+	return strings.HasSuffix(strings.ToUpper(algorithm), "-SESS")
+}
+
+func digestHashFunc(algorithm string) (func() hash.Hash, bool) {
+	newHash, ok := digestHashFuncs[strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")]
+	return newHash, ok
+}
+
+func digestHash(algorithm string, data string) string { //algorithm要先用digestHashFunc检查过
+	newHash, ok := digestHashFunc(algorithm)
+	if !ok {
+		panic(ErrUnsupportedDigestAlgorithm)
+	}
+	h := newHash()
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func parseAuthParams(data string) map[string]string { //解析k1="v1", k2=v2这样的认证参数
+	params := make(map[string]string)
+	for len(data) > 0 {
+		data = strings.TrimLeft(data, " \t,")
+		index := strings.IndexByte(data, '=')
+		if index <= 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(data[:index]))
+		data = strings.TrimLeft(data[index+1:], " \t")
+		var value strings.Builder
+		if strings.HasPrefix(data, `"`) {
+			i := 1
+			for ; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' && i+1 < len(data) {
+					i++
+				}
+				value.WriteByte(data[i])
+			}
+			if i < len(data) {
+				i++
+			}
+			data = data[i:]
+		} else {
+			index = strings.IndexByte(data, ',')
+			if index == -1 {
+				index = len(data)
+			}
+			value.WriteString(strings.TrimSpace(data[:index]))
+			data = data[index:]
+		}
+		params[key] = value.String()
+	}
+	return params
+}
+
+type DigestAuthConfig struct { //Digest认证中间件（RFC 7616）的配置
+	Realm        string
+	Credentials  CredentialStore
+	Algorithms   []string      //按偏好顺序发出的算法，默认只有SHA-256，可以加上MD5兼容旧客户端；支持MD5、SHA-256、SHA-512-256和它们的-sess变体，其他的会panic
+	NonceTimeout time.Duration //nonce的有效期，过期后让客户端用stale=true重试
+}
+
+type digestNonceCounter struct { //记录每个nonce用过的最大nc，防止重放；分新旧两代，每过ttl整代丢掉，不用逐个检查过期
+	lock     sync.Mutex
+	ttl      time.Duration
+	rotateAt time.Time
+	current  map[string]uint64
+	previous map[string]uint64
+}
+
+func newDigestNonceCounter(ttl time.Duration) *digestNonceCounter {
+	return &digestNonceCounter{ttl: ttl, rotateAt: time.Now().Add(ttl), current: make(map[string]uint64), previous: make(map[string]uint64)}
+}
+
+func (counter *digestNonceCounter) check(nonce string, nc uint64) bool {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	if now := time.Now(); now.After(counter.rotateAt) { //nonce最多有效ttl，在旧一代里待满一个周期的记录对应的nonce肯定已经过期
+		if now.Sub(counter.rotateAt) >= counter.ttl { //超过一个周期没有请求，两代都过期了
+			counter.previous = make(map[string]uint64)
+		} else {
+			counter.previous = counter.current
+		}
+		counter.current = make(map[string]uint64)
+		counter.rotateAt = now.Add(counter.ttl)
+	}
+	last, ok := counter.current[nonce]
+	if !ok {
+		last = counter.previous[nonce]
+	}
+	if nc <= last {
+		return false
+	}
+	counter.current[nonce] = nc
+	return true
+}
+
+func DigestAuthMiddleware(config DigestAuthConfig) Middleware { //Digest认证中间件，通过后用request.User拿到用户名
+	if config.Realm == "" {
+		config.Realm = defaultAuthRealm
+	}
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = []string{"SHA-256"}
+	}
+	for i := 0; i < len(config.Algorithms); i++ {
+		if _, ok := digestHashFunc(config.Algorithms[i]); !ok {
+			panic(ErrUnsupportedDigestAlgorithm)
+		}
+	}
+	if config.NonceTimeout == 0 {
+		config.NonceTimeout = defaultDigestNonceTimeout
+	}
+	secret := generateRandomBytes(32)
+	counter := newDigestNonceCounter(config.NonceTimeout)
+	opaque := generateRandomString(16)
+
+	newNonce := func() string { //nonce = 时间戳 + 随机数 + HMAC，不用在服务端保存；随机数保证同一秒发出的nonce也不同，nc不会被别的客户端占用
+		data := make([]byte, digestNonceDataSize, digestNonceDataSize+sha256.Size)
+		binary.BigEndian.PutUint64(data, uint64(time.Now().Unix()))
+		copy(data[8:], generateRandomBytes(digestNonceDataSize-8))
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(data))
+	}
+	checkNonce := func(nonce string) (time.Time, bool) {
+		data, err := base64.RawURLEncoding.DecodeString(nonce)
+		if err != nil || len(data) != digestNonceDataSize+sha256.Size {
+			return time.Time{}, false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data[:digestNonceDataSize])
+		if !hmac.Equal(mac.Sum(nil), data[digestNonceDataSize:]) {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(binary.BigEndian.Uint64(data[:8])), 0).Add(config.NonceTimeout), true
+	}
+	challenges := func(stale bool) []string {
+		list := make([]string, 0, len(config.Algorithms))
+		for i := 0; i < len(config.Algorithms); i++ {
+			challenge := "Digest realm=" + quoteAuthParam(config.Realm) + `, qop="auth", algorithm=` + config.Algorithms[i] + ", nonce=" + quoteAuthParam(newNonce()) + ", opaque=" + quoteAuthParam(opaque)
+			if stale {
+				challenge += ", stale=true"
+			}
+			list = append(list, challenge)
+		}
+		return list
+	}
+
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			authorization := request.Header.Get("Authorization")
+			if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Digest ") {
+				return buildUnauthorizedResponse(challenges(false)...)
+			}
+			params := parseAuthParams(authorization[7:])
+			algorithm := params["algorithm"]
+			if algorithm == "" {
+				algorithm = "MD5"
+			}
+			if !containsFold(config.Algorithms, algorithm) || params["qop"] != "auth" || params["realm"] != config.Realm || params["opaque"] != opaque {
+				return buildUnauthorizedResponse(challenges(false)...)
+			}
+			uri := request.Path
+			if request.UrlParameter != "" {
+				uri += "?" + request.UrlParameter
+			}
+			if params["uri"] != uri {
+				return Build400Response()
+			}
+			expire, ok := checkNonce(params["nonce"])
+			if !ok {
+				return buildUnauthorizedResponse(challenges(false)...)
+			}
+			if time.Now().After(expire) {
+				return buildUnauthorizedResponse(challenges(true)...)
+			}
+			ha1, ok := config.Credentials.DigestHA1(params["username"], config.Realm, algorithm)
+			if !ok {
+				ha1 = digestHash(algorithm, generateRandomString(16))
+			}
+			if isDigestSessAlgorithm(algorithm) { //-sess的HA1还要带上nonce和cnonce
+				ha1 = digestHash(algorithm, ha1+":"+params["nonce"]+":"+params["cnonce"])
+			}
+			ha2 := digestHash(algorithm, request.Method+":"+params["uri"])
+			expected := digestHash(algorithm, ha1+":"+params["nonce"]+":"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2)
+			if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) != 1 || !ok {
+				return buildUnauthorizedResponse(challenges(false)...)
+			}
+			nc, err := strconv.ParseUint(params["nc"], 16, 64)
+			if err != nil || !counter.check(params["nonce"], nc) {
+				return buildUnauthorizedResponse(challenges(true)...)
+			}
+			request.User = params["username"]
+			return next(request)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package simpwebserv
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuthParse(t *testing.T) {
+	tests := []struct {
+		header   string
+		username string
+		password string
+		ok       bool
+	}{
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("alice:pa:ss")), "alice", "pa:ss", true},
+		{"basic " + base64.StdEncoding.EncodeToString([]byte("alice:")), "alice", "", true},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("alice")), "", "", false},
+		{"Basic !!!", "", "", false},
+		{"Bearer abc", "", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		request := newTestRequest(t, "GET", "/")
+		request.Header.Set("Authorization", test.header)
+		username, password, ok := request.BasicAuth()
+		if username != test.username || password != test.password || ok != test.ok {
+			t.Errorf("%q: got %q, %q, %v", test.header, username, password, ok)
+		}
+	}
+}
+
+func TestStaticCredentials(t *testing.T) {
+	credentials := StaticCredentials{"alice": "secret"}
+	if !credentials.VerifyPassword("alice", "secret") {
+		t.Error("correct password rejected")
+	}
+	if credentials.VerifyPassword("alice", "wrong") || credentials.VerifyPassword("bob", "secret") {
+		t.Error("wrong credentials accepted")
+	}
+}
+
+func TestHtpasswdCredentials(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("bcrypt pass"), bcrypt.MinCost)
+	shaSum := sha1.Sum([]byte("sha pass"))
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# comment\nalice:" + string(bcryptHash) + "\nbob:{SHA}" + base64.StdEncoding.EncodeToString(shaSum[:]) + "\ncarol:plaintext\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := LoadHtpasswdFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !credentials.VerifyPassword("alice", "bcrypt pass") || !credentials.VerifyPassword("bob", "sha pass") {
+		t.Error("correct password rejected")
+	}
+	if credentials.VerifyPassword("alice", "sha pass") || credentials.VerifyPassword("bob", "bcrypt pass") || credentials.VerifyPassword("nobody", "x") {
+		t.Error("wrong password accepted")
+	}
+	if credentials.VerifyPassword("carol", "plaintext") {
+		t.Error("unsupported hash format should never match")
+	}
+	if cost, err := bcrypt.Cost([]byte(credentials.dummyHash)); err != nil || cost != bcrypt.MinCost {
+		t.Errorf("unknown users should be compared against a bcrypt hash of the same cost, got %q", credentials.dummyHash)
+	}
+	if credentials.VerifyPassword("nobody", "") {
+		t.Error("unknown user accepted")
+	}
+}
+
+func TestDigestNonceCounter(t *testing.T) {
+	counter := newDigestNonceCounter(time.Minute)
+	if !counter.check("a", 1) || !counter.check("a", 2) || !counter.check("b", 1) {
+		t.Fatal("increasing nc rejected")
+	}
+	if counter.check("a", 2) || counter.check("a", 1) {
+		t.Fatal("replayed nc accepted")
+	}
+	counter.rotateAt = time.Now().Add(-time.Second) //过了一个周期，旧的记录还要能挡住重放
+	if counter.check("a", 2) || !counter.check("a", 3) {
+		t.Fatal("nc from the previous generation was lost")
+	}
+	counter.rotateAt = time.Now().Add(-time.Second)
+	counter.check("c", 1)
+	if len(counter.previous) != 1 || counter.previous["a"] != 3 || len(counter.current) != 1 {
+		t.Fatalf("b was not used for a whole period and should be dropped, got %v %v", counter.previous, counter.current)
+	}
+	counter.rotateAt = time.Now().Add(-2 * time.Minute)
+	counter.check("d", 1)
+	if len(counter.previous) != 0 || len(counter.current) != 1 {
+		t.Fatalf("after a whole idle period both generations should be dropped, got %v %v", counter.previous, counter.current)
+	}
+}
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	handler := Chain(func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Body.WriteString(request.User)
+		return response
+	}, BasicAuthMiddleware(BasicAuthConfig{Realm: "test", Credentials: StaticCredentials{"alice": "secret"}}))
+
+	response := handler(newTestRequest(t, "GET", "/"))
+	if response.Code != "401" || response.Header.Get("WWW-Authenticate") != `Basic realm="test", charset="UTF-8"` {
+		t.Fatalf("got %s %q", response.Code, response.Header.Get("WWW-Authenticate"))
+	}
+	request := newTestRequest(t, "GET", "/")
+	request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:secret")))
+	if response = handler(request); response.Code != "200" || response.Body.String() != "alice" {
+		t.Fatalf("got %s %q", response.Code, response.Body.String())
+	}
+}
+
+func TestDigestHashRFC7616(t *testing.T) { //RFC 7616第3.9.1节的例子
+	ha1Data := "Mufasa:http-auth@example.org:Circle of Life"
+	ha2Data := "GET:/dir/index.html"
+	rest := ":7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v:00000001:f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ:auth:"
+	expected := map[string]string{
+		"MD5":     "8ca523f5e9506fed4657c9700eebdbec",
+		"SHA-256": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	}
+	for algorithm, want := range expected {
+		got := digestHash(algorithm, digestHash(algorithm, ha1Data)+rest+digestHash(algorithm, ha2Data))
+		if got != want {
+			t.Errorf("%s: got %s, want %s", algorithm, got, want)
+		}
+	}
+}
+
+func TestDigestHashFunc(t *testing.T) {
+	for _, algorithm := range []string{"MD5", "md5-sess", "SHA-256", "SHA-256-sess", "SHA-512-256", "SHA-512-256-SESS"} {
+		if _, ok := digestHashFunc(algorithm); !ok {
+			t.Errorf("%s should be supported", algorithm)
+		}
+	}
+	for _, algorithm := range []string{"", "SHA-512", "SHA-1", "MD5-sess-sess", "token"} {
+		if _, ok := digestHashFunc(algorithm); ok {
+			t.Errorf("%s should not be supported", algorithm)
+		}
+	}
+	if digestHash("SHA-512-256", "abc") != "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23" { //FIPS 180-4的例子
+		t.Error("SHA-512-256 digest mismatch")
+	}
+}
+
+func TestDigestAuthMiddlewareRejectsUnsupportedAlgorithm(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrUnsupportedDigestAlgorithm {
+			t.Fatalf("got %v, want ErrUnsupportedDigestAlgorithm panic", r)
+		}
+	}()
+	DigestAuthMiddleware(DigestAuthConfig{Credentials: StaticCredentials{}, Algorithms: []string{"SHA-256", "SHA-512"}})
+}
+
+var testDigestHashes = map[string]func() hash.Hash{"MD5": md5.New, "SHA-256": sha256.New, "SHA-512-256": sha512.New512_256}
+
+func testDigestResponse(algorithm string, username string, realm string, password string, method string, uri string, nonce string, nc string, cnonce string) string { //按RFC 7616独立算一遍客户端的response
+	newHash := testDigestHashes[strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")]
+	h := func(data string) string {
+		sum := newHash()
+		sum.Write([]byte(data))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+	ha1 := h(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	return h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":auth:" + h(method+":"+uri))
+}
+
+func TestDigestAuthMiddleware(t *testing.T) {
+	algorithms := []string{"SHA-512-256", "SHA-512-256-sess", "SHA-256", "SHA-256-sess", "MD5", "MD5-sess"}
+	handler := Chain(func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Body.WriteString(request.User)
+		return response
+	}, DigestAuthMiddleware(DigestAuthConfig{Realm: "test", Credentials: StaticCredentials{"alice": "secret"}, Algorithms: algorithms}))
+
+	challengeResponse := handler(newTestRequest(t, "GET", "/private"))
+	challenges := challengeResponse.Header.Values("WWW-Authenticate")
+	if challengeResponse.Code != "401" || len(challenges) != len(algorithms) {
+		t.Fatalf("got %s with %d challenges", challengeResponse.Code, len(challenges))
+	}
+
+	send := func(params map[string]string) *Response {
+		request := newTestRequest(t, "GET", "/private")
+		request.UrlParameter = "a=1"
+		parts := make([]string, 0, len(params))
+		for key, value := range params {
+			parts = append(parts, key+"="+quoteAuthParam(value))
+		}
+		request.Header.Set("Authorization", "Digest "+strings.Join(parts, ", "))
+		return handler(request)
+	}
+	for i, algorithm := range algorithms {
+		challenge := parseAuthParams(strings.TrimPrefix(challenges[i], "Digest "))
+		if challenge["algorithm"] != algorithm {
+			t.Fatalf("challenge %d advertises %q, want %q", i, challenge["algorithm"], algorithm)
+		}
+		params := map[string]string{"username": "alice", "realm": "test", "uri": "/private?a=1", "algorithm": algorithm, "qop": "auth", "nc": "00000001", "cnonce": "client nonce", "nonce": challenge["nonce"], "opaque": challenge["opaque"]}
+		params["response"] = testDigestResponse(algorithm, "alice", "test", "secret", "GET", params["uri"], params["nonce"], params["nc"], params["cnonce"])
+		if response := send(params); response.Code != "200" || response.Body.String() != "alice" {
+			t.Errorf("%s: got %s", algorithm, response.Code)
+		}
+		if response := send(params); response.Code != "401" { //同一个nc重放
+			t.Errorf("%s replay: got %s", algorithm, response.Code)
+		}
+		params["nc"] = "00000002"
+		params["response"] = testDigestResponse(algorithm, "alice", "test", "wrong", "GET", params["uri"], params["nonce"], params["nc"], params["cnonce"])
+		if response := send(params); response.Code != "401" {
+			t.Errorf("%s wrong password: got %s", algorithm, response.Code)
+		}
+		params["username"] = "nobody"
+		params["response"] = testDigestResponse(algorithm, "nobody", "test", "secret", "GET", params["uri"], params["nonce"], params["nc"], params["cnonce"])
+		if response := send(params); response.Code != "401" {
+			t.Errorf("%s unknown user: got %s", algorithm, response.Code)
+		}
+	}
+
+	challenge := parseAuthParams(strings.TrimPrefix(challenges[0], "Digest "))
+	params := map[string]string{"username": "alice", "realm": "test", "uri": "/private?a=1", "algorithm": "SHA-256", "qop": "auth", "nc": "00000001", "cnonce": "c", "nonce": challenge["nonce"] + "x", "opaque": challenge["opaque"]}
+	params["response"] = testDigestResponse("SHA-256", "alice", "test", "secret", "GET", params["uri"], params["nonce"], params["nc"], params["cnonce"])
+	if response := send(params); response.Code != "401" {
+		t.Errorf("forged nonce: got %s", response.Code)
+	}
+	params["nonce"] = challenge["nonce"]
+	params["uri"] = "/other"
+	if response := send(params); response.Code != "400" {
+		t.Errorf("uri mismatch: got %s", response.Code)
+	}
+}
+
+func TestDigestAuthMiddlewareAlgorithmNotOffered(t *testing.T) {
+	handler := Chain(func(request *Request) *Response { return BuildBasicResponse() }, DigestAuthMiddleware(DigestAuthConfig{Realm: "test", Credentials: StaticCredentials{"alice": "secret"}}))
+	challenge := parseAuthParams(strings.TrimPrefix(handler(newTestRequest(t, "GET", "/")).Header.Get("WWW-Authenticate"), "Digest "))
+	params := map[string]string{"username": "alice", "realm": "test", "uri": "/", "algorithm": "MD5", "qop": "auth", "nc": "00000001", "cnonce": "c", "nonce": challenge["nonce"], "opaque": challenge["opaque"]}
+	params["response"] = testDigestResponse("MD5", "alice", "test", "secret", "GET", "/", params["nonce"], params["nc"], params["cnonce"])
+	parts := []string{}
+	for key, value := range params {
+		parts = append(parts, key+"="+quoteAuthParam(value))
+	}
+	request := newTestRequest(t, "GET", "/")
+	request.Header.Set("Authorization", "Digest "+strings.Join(parts, ", "))
+	if response := handler(request); response.Code != "401" {
+		t.Fatalf("MD5 is not offered by default, got %s", response.Code)
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`username="a\"b", realm="r,1" ,nc=00000001, qop=auth`)
+	want := map[string]string{"username": `a"b`, "realm": "r,1", "nc": "00000001", "qop": "auth"}
+	for key, value := range want {
+		if params[key] != value {
+			t.Errorf("%s: got %q, want %q", key, params[key], value)
+		}
+	}
+}
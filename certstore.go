@@ -0,0 +1,252 @@
+package simpwebserv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const defaultTlsReloadInterval = time.Minute
+
+type TlsCertificatePair struct { //一对证书和私钥文件
+	PemPath string
+	KeyPath string
+}
+
+type tlsCertEntry struct {
+	pemPath    string //为空时是内存里的证书，不会被重新加载
+	keyPath    string
+	cert       *tls.Certificate
+	pemModTime time.Time
+	keyModTime time.Time
+}
+
+type tlsCertStore struct { //按SNI选择证书，支持通配符和默认证书，可以在运行时重新加载
+	lock      sync.RWMutex
+	entries   []*tlsCertEntry
+	names     map[string]*tls.Certificate
+	wildcards map[string]*tls.Certificate
+}
+
+func newTlsCertStore() *tlsCertStore {
+	return &tlsCertStore{names: make(map[string]*tls.Certificate), wildcards: make(map[string]*tls.Certificate)}
+}
+
+func certificateNames(cert *tls.Certificate) []string { //证书里的所有域名
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil
+		}
+		cert.Leaf = leaf
+	}
+	if len(leaf.DNSNames) == 0 && leaf.Subject.CommonName != "" {
+		return []string{leaf.Subject.CommonName}
+	}
+	names := append([]string(nil), leaf.DNSNames...)
+	for i := 0; i < len(leaf.IPAddresses); i++ {
+		names = append(names, leaf.IPAddresses[i].String())
+	}
+	return names
+}
+
+func fileModTime(path string) time.Time {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
+
+func (store *tlsCertStore) rebuildIndex() { //重新生成域名索引，调用时要持有写锁
+	store.names = make(map[string]*tls.Certificate)
+	store.wildcards = make(map[string]*tls.Certificate)
+	for i := len(store.entries) - 1; i >= 0; i-- { //倒着遍历，前面加入的证书优先
+		cert := store.entries[i].cert
+		names := certificateNames(cert)
+		for j := 0; j < len(names); j++ {
+			name := strings.ToLower(names[j])
+			if strings.HasPrefix(name, "*.") {
+				store.wildcards[name[2:]] = cert
+			} else {
+				store.names[name] = cert
+			}
+		}
+	}
+}
+
+func (store *tlsCertStore) addFile(pemPath string, keyPath string) error { //添加一对证书文件
+	cert, err := tls.LoadX509KeyPair(pemPath, keyPath)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	store.entries = append(store.entries, &tlsCertEntry{pemPath, keyPath, &cert, fileModTime(pemPath), fileModTime(keyPath)})
+	store.rebuildIndex()
+	store.lock.Unlock()
+	return nil
+}
+
+func (store *tlsCertStore) setCertificate(cert *tls.Certificate) { //添加或替换一个内存里的证书（按域名替换）
+	names := certificateNames(cert)
+	store.lock.Lock()
+	replaced := false
+	for i := 0; i < len(store.entries); i++ {
+		if store.entries[i].pemPath == "" && strings.Join(certificateNames(store.entries[i].cert), ",") == strings.Join(names, ",") {
+			store.entries[i].cert = cert
+			replaced = true
+		}
+	}
+	if !replaced {
+		store.entries = append(store.entries, &tlsCertEntry{cert: cert})
+	}
+	store.rebuildIndex()
+	store.lock.Unlock()
+}
+
+func (store *tlsCertStore) clear() {
+	store.lock.Lock()
+	store.entries = nil
+	store.rebuildIndex()
+	store.lock.Unlock()
+}
+
+func (store *tlsCertStore) empty() bool {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return len(store.entries) == 0
+}
+
+func (store *tlsCertStore) hasFiles() bool { //有没有需要监视的证书文件
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	for i := 0; i < len(store.entries); i++ {
+		if store.entries[i].pemPath != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *tlsCertStore) reload(force bool) (bool, error) { //重新读取改动过的证书文件，出错时保留旧证书
+	store.lock.RLock()
+	entries := append([]*tlsCertEntry(nil), store.entries...)
+	store.lock.RUnlock()
+	changed := false
+	var firstErr error
+	newEntries := make([]*tlsCertEntry, len(entries))
+	for i := 0; i < len(entries); i++ {
+		newEntries[i] = entries[i]
+		if entries[i].pemPath == "" {
+			continue
+		}
+		pemModTime := fileModTime(entries[i].pemPath)
+		keyModTime := fileModTime(entries[i].keyPath)
+		if !force && pemModTime.Equal(entries[i].pemModTime) && keyModTime.Equal(entries[i].keyModTime) {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(entries[i].pemPath, entries[i].keyPath)
+		if err != nil { //可能文件正写到一半，下次再试
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		newEntries[i] = &tlsCertEntry{entries[i].pemPath, entries[i].keyPath, &cert, pemModTime, keyModTime}
+		changed = true
+	}
+	if changed {
+		store.lock.Lock()
+		if len(store.entries) == len(newEntries) {
+			copy(store.entries, newEntries)
+		}
+		store.rebuildIndex()
+		store.lock.Unlock()
+	}
+	return changed, firstErr
+}
+
+func (store *tlsCertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) { //按SNI选证书：完全匹配 > 通配符 > 默认（第一个添加的）
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	if len(store.entries) == 0 {
+		return nil, ErrNoCertificateFound
+	}
+	if cert, ok := store.names[name]; ok {
+		return cert, nil
+	}
+	if index := strings.IndexByte(name, '.'); index != -1 {
+		if cert, ok := store.wildcards[name[index+1:]]; ok {
+			return cert, nil
+		}
+	}
+	return store.entries[0].cert, nil
+}
+
+func (app *AppStruct) tlsNextProtos() []string { //只有启用了ACME才声明acme-tls/1，否则协商到它的握手会被当成验证连接关掉
+	if app.acmeManager != nil {
+		return []string{"http/1.1", acme.ALPNProto}
+	}
+	return []string{"http/1.1"}
+}
+
+func (app *AppStruct) newTlsConfig() *tls.Config { //生成用证书库选证书的TLS配置
+	config := &tls.Config{GetCertificate: app.getCertificate, NextProtos: app.tlsNextProtos()}
+	config.ClientCAs = app.tlsClientCAs
+	config.ClientAuth = app.tlsClientAuth
+	return config
+}
+
+func (app *AppStruct) AddTlsCertificate(pemPath string, keyPath string) error { //再添加一对证书，按SNI选择，第一个添加的是默认证书
+	if err := app.tlsCertStore.addFile(pemPath, keyPath); err != nil {
+		return err
+	}
+	app.useTls = true
+	if app.HTTPSConfig == nil {
+		app.HTTPSConfig = app.newTlsConfig()
+	}
+	return nil
+}
+
+func (app *AppStruct) ReloadTlsCertificates() error { //强制重新读取所有证书文件
+	_, err := app.tlsCertStore.reload(true)
+	return err
+}
+
+func (app *AppStruct) watchTlsCertificates(interval time.Duration) { //定时检查证书文件是否有变化，收到SIGHUP时立即重新读取
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		force := false
+		select {
+		case <-tick:
+		case <-signalChan:
+			force = true
+		}
+		changed, err := app.tlsCertStore.reload(force)
+		if app.enableConsoleLog {
+			if err != nil {
+				log.Println("TLS certificate reload error: " + err.Error())
+			} else if changed {
+				log.Println("TLS certificates reloaded")
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package simpwebserv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func newTestServerCertificate(t *testing.T, names ...string) *tls.Certificate { //自签名的服务器证书，第一个名字放在CN里
+	cert, key := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: names[0]}, DNSNames: names}, nil, nil)
+	return &tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}
+}
+
+func TestTlsCertStoreSelection(t *testing.T) {
+	store := newTlsCertStore()
+	if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"}); err != ErrNoCertificateFound {
+		t.Fatalf("got %v from an empty store, want ErrNoCertificateFound", err)
+	}
+	defaultCert := newTestServerCertificate(t, "default.test")
+	wildcardCert := newTestServerCertificate(t, "*.example.com")
+	exactCert := newTestServerCertificate(t, "api.example.com")
+	shadowedCert := newTestServerCertificate(t, "default.test", "other.test")
+	store.setCertificate(defaultCert)
+	store.setCertificate(wildcardCert)
+	store.setCertificate(exactCert)
+	store.setCertificate(shadowedCert)
+
+	tests := []struct {
+		serverName string
+		want       *tls.Certificate
+	}{
+		{"api.example.com", exactCert},
+		{"API.Example.com.", exactCert},
+		{"www.example.com", wildcardCert},
+		{"a.b.example.com", defaultCert}, //通配符只匹配一层
+		{"example.com", defaultCert},
+		{"default.test", defaultCert}, //两个证书都有这个名字时先添加的优先
+		{"other.test", shadowedCert},
+		{"", defaultCert},
+		{"unknown.org", defaultCert},
+	}
+	for _, test := range tests {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: test.serverName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert != test.want {
+			t.Fatalf("%q: got certificate for %v, want %v", test.serverName, cert.Leaf.DNSNames, test.want.Leaf.DNSNames)
+		}
+	}
+
+	renewedCert := newTestServerCertificate(t, "*.example.com")
+	store.setCertificate(renewedCert)
+	if cert, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"}); cert != renewedCert {
+		t.Fatal("setCertificate with the same names should replace the old certificate")
+	}
+	if cert, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.org"}); cert != defaultCert {
+		t.Fatal("replacing a certificate must not change the default")
+	}
+}
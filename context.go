@@ -0,0 +1,67 @@
+package simpwebserv
+
+import (
+	"context"
+	"time"
+)
+
+var abortReadDeadline = time.Unix(1, 0) //设置成过去的时间让阻塞的Read马上返回
+
+type disconnectWatcher struct { //处理函数运行时在后台读连接，读到EOF说明客户端断开了
+	done   chan struct{}
+	buffer [1]byte
+	n      int
+}
+
+func (request *Request) Context() context.Context { //请求的context，客户端断开连接、服务器平滑退出超时或者中间件设置的超时到了会被取消，处理函数返回后也会被取消
+	if request.ctx == nil {
+		return context.Background()
+	}
+	request.contextUsed = true
+	request.watchDisconnect()
+	return request.ctx
+}
+
+func (request *Request) SetContext(ctx context.Context) { //替换请求的context，ctx应当由Context()派生，比如加上超时
+	request.ctx = ctx
+}
+
+func (request *Request) WithValue(key interface{}, value interface{}) { //在请求的context里保存值，给后面的中间件和处理函数用
+	request.ctx = context.WithValue(request.Context(), key, value)
+}
+
+func (request *Request) Value(key interface{}) interface{} { //读取WithValue保存的值
+	return request.Context().Value(key)
+}
+
+func (request *Request) watchDisconnect() { //body读完之后才能在后台读连接，没读完的话在ConnRead读完时再开始
+	if request.watcher != nil || request.cancel == nil || !request.contextUsed || request.bodyReaded < request.bodyBytes || len(request.readRestData) != 0 {
+		return
+	}
+	watcher := &disconnectWatcher{done: make(chan struct{})}
+	request.watcher = watcher
+	conn := request.conn
+	cancel := request.cancel
+	conn.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(watcher.done)
+		watcher.n, _ = conn.Read(watcher.buffer[:])
+		if watcher.n == 0 { //断开了，或者是处理函数已经返回被stopWatchDisconnect打断
+			cancel()
+		}
+	}()
+}
+
+func (request *Request) stopWatchDisconnect() []byte { //处理函数返回后取消context并停止后台读，返回后台读到的下一个请求的数据
+	if request.cancel != nil {
+		request.cancel()
+	}
+	watcher := request.watcher
+	if watcher == nil {
+		return nil
+	}
+	request.watcher = nil
+	request.conn.SetReadDeadline(abortReadDeadline)
+	<-watcher.done
+	return watcher.buffer[:watcher.n]
+}
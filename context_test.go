@@ -0,0 +1,69 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func servePipe(t *testing.T, app *AppStruct) net.Conn { //用内存管道直接跑connectionHandler，返回客户端这一端
+	client, server := net.Pipe()
+	app.enableConsoleLog = false
+	go connectionHandler(server, app)
+	t.Cleanup(func() {
+		client.Close()
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client
+}
+
+func TestContextCanceledOnDisconnect(t *testing.T) {
+	canceled := make(chan error, 1)
+	app := App()
+	app.Register(func(request *Request) *Response {
+		ctx := request.Context()
+		select {
+		case <-ctx.Done():
+			canceled <- ctx.Err()
+		case <-time.After(5 * time.Second):
+			canceled <- nil
+		}
+		return BuildBasicResponse()
+	}, "/", false)
+	client := servePipe(t, app)
+	if _, err := client.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if err := <-canceled; err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled after the client went away", err)
+	}
+}
+
+func TestContextWatcherKeepsPipelinedBytes(t *testing.T) {
+	app := App()
+	app.SetEnableKeepAlive(true)
+	app.Register(func(request *Request) *Response {
+		request.Context()
+		<-request.watcher.done //后台读已经读到了下一个请求的第一个字节
+		response := BuildBasicResponse()
+		response.Body.WriteString("first")
+		return response
+	}, "/first", false)
+	app.Register(func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Body.WriteString("second " + request.Method)
+		return response
+	}, "/second", false)
+	client := servePipe(t, app)
+	go client.Write([]byte("GET /first HTTP/1.1\r\nHost: a\r\n\r\nGET /second HTTP/1.1\r\nHost: a\r\n\r\n")) //管道是同步的，要在另一个goroutine里写
+	reader := bufio.NewReader(client)
+	for _, want := range []string{"first", "second GET"} {
+		response, body := readTestResponse(t, reader)
+		if response.StatusCode != 200 || body != want {
+			t.Fatalf("got %d %q, want %q", response.StatusCode, body, want)
+		}
+	}
+}
@@ -2,26 +2,125 @@ package simpwebserv
 
 import (
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func (request *Request) DecodeCookie() map[string]string {
-	cookieMap := make(map[string]string)
-	var cookieHeader string
-	var ok bool
-	if cookieHeader, ok = request.Header["Cookie"]; !ok {
-		if cookieHeader, ok = request.Header["cookie"]; !ok {
-			return cookieMap
+const (
+	SameSiteDefault = ""
+	SameSiteLax     = "Lax"
+	SameSiteStrict  = "Strict"
+	SameSiteNone    = "None"
+)
+
+type Cookie struct { //Set-Cookie用的cookie结构体
+	Name        string
+	Value       string
+	Domain      string
+	Path        string
+	Expires     time.Time
+	MaxAge      int //0为不设置，小于0为立即过期（Max-Age=0）
+	Secure      bool
+	HttpOnly    bool
+	SameSite    string
+	Partitioned bool
+}
+
+func sanitizeCookieAttribute(value string) string { //去掉属性里会破坏header的字符
+	return strings.Map(func(r rune) rune {
+		if r == ';' || r == '\r' || r == '\n' || r < 0x20 || r == 0x7f {
+			return -1
 		}
+		return r
+	}, value)
+}
+
+func (cookie *Cookie) String() string { //生成Set-Cookie的值
+	var builder strings.Builder
+	builder.WriteString(url.QueryEscape(cookie.Name))
+	builder.WriteString("=")
+	builder.WriteString(url.QueryEscape(cookie.Value))
+	if cookie.Domain != "" {
+		builder.WriteString("; Domain=")
+		builder.WriteString(strings.TrimPrefix(sanitizeCookieAttribute(cookie.Domain), "."))
 	}
-	cookieList := strings.Split(cookieHeader, "; ")
-	var cookieFirst string
-	for i := 0; i < len(cookieList); i++ {
-		singleCookieList := strings.Split(cookieList[i], "=")
-		if len(singleCookieList) == 2 {
-			cookieFirst, _ = url.QueryUnescape(singleCookieList[0])
-			cookieMap[cookieFirst], _ = url.QueryUnescape(singleCookieList[1])
+	if cookie.Path != "" {
+		builder.WriteString("; Path=")
+		builder.WriteString(sanitizeCookieAttribute(cookie.Path))
+	}
+	if !cookie.Expires.IsZero() {
+		builder.WriteString("; Expires=")
+		builder.WriteString(formatGMTTime(cookie.Expires))
+	}
+	if cookie.MaxAge > 0 {
+		builder.WriteString("; Max-Age=")
+		builder.WriteString(strconv.Itoa(cookie.MaxAge))
+	} else if cookie.MaxAge < 0 {
+		builder.WriteString("; Max-Age=0")
+	}
+	if cookie.Secure || cookie.SameSite == SameSiteNone || cookie.Partitioned { //SameSite=None和Partitioned都要求Secure
+		builder.WriteString("; Secure")
+	}
+	if cookie.HttpOnly {
+		builder.WriteString("; HttpOnly")
+	}
+	if cookie.SameSite != SameSiteDefault {
+		builder.WriteString("; SameSite=")
+		builder.WriteString(cookie.SameSite)
+	}
+	if cookie.Partitioned {
+		builder.WriteString("; Partitioned")
+	}
+	return builder.String()
+}
+
+func (response *Response) SetCookie(cookie *Cookie) { //添加一个Set-Cookie
+	response.SetCookieList = append(response.SetCookieList, cookie.String())
+}
+
+func (response *Response) DeleteCookie(cookie *Cookie) { //让浏览器删除一个cookie（Name、Domain、Path要和设置时一致）
+	deleteCookie := *cookie
+	deleteCookie.Value = ""
+	deleteCookie.Expires = time.Unix(0, 0)
+	deleteCookie.MaxAge = -1
+	response.SetCookie(&deleteCookie)
+}
+
+func parseCookieHeader(cookieHeader string, cookieMap map[string]string) { //按RFC 6265解析Cookie头
+	for _, pair := range strings.Split(cookieHeader, ";") {
+		pair = strings.TrimSpace(pair)
+		index := strings.IndexByte(pair, '=')
+		if index <= 0 {
+			continue
+		}
+		name := strings.TrimSpace(pair[:index])
+		value := strings.TrimSpace(pair[index+1:])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
 		}
+		if _, ok := cookieMap[name]; !ok { //同名cookie以第一个（路径最长的）为准
+			cookieMap[name] = value
+		}
+	}
+}
+
+func (request *Request) DecodeCookie() map[string]string {
+	cookieMap := make(map[string]string)
+	cookieHeaderList := request.Header.Values("Cookie")
+	for i := 0; i < len(cookieHeaderList); i++ {
+		parseCookieHeader(cookieHeaderList[i], cookieMap)
 	}
 	return cookieMap
 }
+
+func (request *Request) GetCookie(name string) (string, bool) { //获取单个cookie
+	value, ok := request.DecodeCookie()[name]
+	return value, ok
+}
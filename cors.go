@@ -0,0 +1,127 @@
+package simpwebserv
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CORSConfig struct { //CORS中间件的配置
+	AllowOrigins     []string //允许的来源，可以是*、完整来源或带*的模式（如https://*.example.com）
+	AllowOriginFunc  func(origin string) bool
+	AllowMethods     []string //为空时默认允许GET、HEAD、POST、PUT、PATCH、DELETE
+	AllowHeaders     []string //为空时允许预检请求里要求的所有header
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           time.Duration //预检结果的缓存时间
+}
+
+func addVary(header Header, value string) { //往Vary里加一项，已经有了就不加
+	for _, v := range header.Values("Vary") {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+func containsFold(list []string, value string) bool {
+	for i := 0; i < len(list); i++ {
+		if strings.EqualFold(list[i], value) {
+			return true
+		}
+	}
+	return false
+}
+
+func CORSMiddleware(config CORSConfig) Middleware { //CORS中间件，在路由之前处理预检请求
+	allowAllOrigins := false
+	for i := 0; i < len(config.AllowOrigins); i++ {
+		if config.AllowOrigins[i] == "*" {
+			allowAllOrigins = true
+		}
+	}
+	if len(config.AllowMethods) == 0 {
+		config.AllowMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	allowMethodsString := strings.Join(config.AllowMethods, ", ")
+	allowHeadersString := strings.Join(config.AllowHeaders, ", ")
+	exposeHeadersString := strings.Join(config.ExposeHeaders, ", ")
+
+	originAllowed := func(origin string) bool {
+		if allowAllOrigins {
+			return true
+		}
+		for i := 0; i < len(config.AllowOrigins); i++ {
+			if config.AllowOrigins[i] == origin {
+				return true
+			}
+			if strings.Contains(config.AllowOrigins[i], "*") {
+				if matched, _ := path.Match(config.AllowOrigins[i], origin); matched {
+					return true
+				}
+			}
+		}
+		return config.AllowOriginFunc != nil && config.AllowOriginFunc(origin)
+	}
+	setAllowOrigin := func(header Header, origin string) {
+		if allowAllOrigins && !config.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if config.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			origin, hasOrigin := request.Header.Lookup("Origin")
+			requestMethod, isPreflight := request.Header.Lookup("Access-Control-Request-Method")
+			isPreflight = isPreflight && hasOrigin && request.Method == "OPTIONS"
+
+			if isPreflight { //预检请求直接在这里回复，不进路由
+				if !originAllowed(origin) || !containsFold(config.AllowMethods, requestMethod) {
+					response := Build403Response()
+					addVary(response.Header, "Origin")
+					return response
+				}
+				response := BuildBasicResponse()
+				response.Code = "204"
+				response.CodeName = "No Content"
+				response.Header.Del("Content-Type")
+				addVary(response.Header, "Origin")
+				addVary(response.Header, "Access-Control-Request-Method")
+				addVary(response.Header, "Access-Control-Request-Headers")
+				setAllowOrigin(response.Header, origin)
+				response.Header.Set("Access-Control-Allow-Methods", allowMethodsString)
+				if allowHeadersString != "" {
+					response.Header.Set("Access-Control-Allow-Headers", allowHeadersString)
+				} else if requestHeaders := request.Header.Get("Access-Control-Request-Headers"); requestHeaders != "" {
+					response.Header.Set("Access-Control-Allow-Headers", requestHeaders)
+				}
+				if config.MaxAge > 0 {
+					response.Header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge/time.Second)))
+				}
+				return response
+			}
+
+			response := next(request)
+			if !allowAllOrigins || config.AllowCredentials { //返回的Access-Control-Allow-Origin跟着Origin变，缓存要区分
+				addVary(response.Header, "Origin")
+			}
+			if hasOrigin && originAllowed(origin) {
+				setAllowOrigin(response.Header, origin)
+				if exposeHeadersString != "" {
+					response.Header.Set("Access-Control-Expose-Headers", exposeHeadersString)
+				}
+			}
+			return response
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"net/http"
+	"testing"
+)
+
+func newCORSTestRequest(t *testing.T, method string, origin string, preflightMethod string) *Request {
+	request := newTestRequest(t, method, "/")
+	if origin != "" {
+		request.Header.Set("Origin", origin)
+	}
+	if preflightMethod != "" {
+		request.Header.Set("Access-Control-Request-Method", preflightMethod)
+	}
+	return request
+}
+
+func TestCORSPreflight(t *testing.T) {
+	called := false
+	handler := Chain(func(request *Request) *Response {
+		called = true
+		return BuildBasicResponse()
+	}, CORSMiddleware(CORSConfig{AllowOrigins: []string{"https://*.example.com"}, AllowMethods: []string{"GET", "PUT"}, AllowHeaders: []string{"X-Token"}}))
+	tests := []struct {
+		name   string
+		origin string
+		method string
+		code   string
+	}{
+		{"allowed", "https://app.example.com", "PUT", "204"},
+		{"method not allowed", "https://app.example.com", "DELETE", "403"},
+		{"origin not matching pattern", "https://example.com", "PUT", "403"},
+		{"extra suffix after the pattern", "https://a.b.example.com.evil.org", "PUT", "403"},
+	}
+	for _, test := range tests {
+		response := handler(newCORSTestRequest(t, "OPTIONS", test.origin, test.method))
+		if response.Code != test.code {
+			t.Fatalf("%s: got %s, want %s", test.name, response.Code, test.code)
+		}
+		if response.Code == "204" {
+			if response.Header.Get("Access-Control-Allow-Origin") != test.origin || response.Header.Get("Access-Control-Allow-Methods") != "GET, PUT" || response.Header.Get("Access-Control-Allow-Headers") != "X-Token" {
+				t.Fatalf("%s: unexpected headers %v", test.name, response.Header)
+			}
+		} else if response.Header.Has("Access-Control-Allow-Origin") {
+			t.Fatalf("%s: rejected preflight must not allow the origin", test.name)
+		}
+	}
+	if called {
+		t.Fatal("preflight requests must not reach the handler")
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	handler := Chain(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, CORSMiddleware(CORSConfig{AllowOrigins: []string{"https://*.example.com"}, ExposeHeaders: []string{"X-Total"}}))
+	response := handler(newCORSTestRequest(t, "GET", "https://app.example.com", ""))
+	if response.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" || response.Header.Get("Access-Control-Expose-Headers") != "X-Total" || response.Header.Get("Vary") != "Origin" {
+		t.Fatalf("unexpected headers for an allowed origin: %v", response.Header)
+	}
+	response = handler(newCORSTestRequest(t, "GET", "https://other.org", ""))
+	if response.Code != "200" || response.Header.Has("Access-Control-Allow-Origin") {
+		t.Fatalf("a disallowed origin should get the response without CORS headers: %v", response.Header)
+	}
+}
+
+func TestCORSCredentialsWithWildcard(t *testing.T) {
+	wildcard := Chain(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, CORSMiddleware(CORSConfig{AllowOrigins: []string{"*"}}))
+	response := wildcard(newCORSTestRequest(t, "GET", "https://a.org", ""))
+	if response.Header.Get("Access-Control-Allow-Origin") != "*" || response.Header.Has("Vary") {
+		t.Fatalf("wildcard without credentials should answer *: %v", response.Header)
+	}
+
+	credentials := Chain(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, CORSMiddleware(CORSConfig{AllowOrigins: []string{"*"}, AllowCredentials: true}))
+	for _, method := range []string{"GET", "OPTIONS"} {
+		preflightMethod := ""
+		if method == "OPTIONS" {
+			preflightMethod = "GET"
+		}
+		response = credentials(newCORSTestRequest(t, method, "https://a.org", preflightMethod))
+		if response.Header.Get("Access-Control-Allow-Origin") != "https://a.org" || response.Header.Get("Access-Control-Allow-Credentials") != "true" || !response.Header.Has("Vary") {
+			t.Fatalf("%s: credentials must echo the origin instead of *: %v", method, response.Header)
+		}
+	}
+}
+
+func TestCORSPreflightOnWire(t *testing.T) { //204不能带Content-Length和body，后面的请求还要能正常读
+	app := App()
+	app.SetEnableKeepAlive(true)
+	app.Use(CORSMiddleware(CORSConfig{AllowOrigins: []string{"*"}}))
+	app.Register(func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Body.WriteString("ok")
+		return response
+	}, "/", false)
+	client := servePipe(t, app)
+	go client.Write([]byte("OPTIONS / HTTP/1.1\r\nHost: a\r\nOrigin: https://a.org\r\nAccess-Control-Request-Method: GET\r\n\r\nGET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	reader := bufio.NewReader(client)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 204 || response.Header.Get("Content-Length") != "" {
+		t.Fatalf("got %d with Content-Length %q, want 204 without it", response.StatusCode, response.Header.Get("Content-Length"))
+	}
+	response.Body.Close()
+	if response, body := readTestResponse(t, reader); response.StatusCode != 200 || body != "ok" {
+		t.Fatalf("got %d %q after the preflight", response.StatusCode, body)
+	}
+}
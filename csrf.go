@@ -0,0 +1,233 @@
+package simpwebserv
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"html"
+	"html/template"
+	"strings"
+)
+
+const (
+	CSRFSynchronizerToken  = iota //token存在会话里，需要先用SessionMiddleware
+	CSRFDoubleSubmitCookie        //token存在cookie里，提交时和cookie里的比较
+)
+
+const (
+	defaultCSRFCookieName = "csrf_token"
+	defaultCSRFFieldName  = "csrf_token"
+	defaultCSRFHeaderName = "X-CSRF-Token"
+	csrfSessionKey        = "_csrf_token"
+	csrfTokenLength       = 32
+)
+
+type CSRFConfig struct { //CSRF中间件的配置
+	Mode           int
+	Keys           *CookieKeys //双重提交模式下用来签名cookie，为nil则不签名
+	CookieName     string
+	CookiePath     string
+	CookieDomain   string
+	Secure         bool
+	SameSite       string
+	FieldName      string   //表单和URL参数里的字段名
+	HeaderName     string   //AJAX请求用的header名
+	TrustedOrigins []string //额外允许的跨站来源，如https://app.example.com
+	ExemptPaths    []string //不检查的路径，以/*结尾表示前缀匹配
+	FailureHandler func(*Request, error) *Response
+}
+
+func csrfSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS" || method == "TRACE"
+}
+
+func csrfPathExempt(path string, exemptPaths []string) bool {
+	for i := 0; i < len(exemptPaths); i++ {
+		if strings.HasSuffix(exemptPaths[i], "/*") {
+			if strings.HasPrefix(path, exemptPaths[i][:len(exemptPaths[i])-1]) {
+				return true
+			}
+		} else if path == exemptPaths[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func (request *Request) IsTls() bool { //是否是TLS连接
+	_, ok := request.tlsConn()
+	return ok
+}
+
+func (request *Request) origin() string { //本服务的来源，如https://example.com
+	if request.IsTls() {
+		return "https://" + request.Header.Get("Host")
+	}
+	return "http://" + request.Header.Get("Host")
+}
+
+func maskCSRFToken(token []byte) string { //每次输出都用随机数异或一遍，防止BREACH攻击
+	otp := generateRandomBytes(len(token))
+	masked := make([]byte, len(token)*2)
+	copy(masked, otp)
+	for i := 0; i < len(token); i++ {
+		masked[len(token)+i] = otp[i] ^ token[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+func unmaskCSRFToken(maskedToken string) []byte {
+	data, err := base64.RawURLEncoding.DecodeString(maskedToken)
+	if err != nil {
+		return nil
+	}
+	if len(data) == csrfTokenLength {
+		return data
+	}
+	if len(data) != csrfTokenLength*2 {
+		return nil
+	}
+	token := make([]byte, csrfTokenLength)
+	for i := 0; i < csrfTokenLength; i++ {
+		token[i] = data[i] ^ data[csrfTokenLength+i]
+	}
+	return token
+}
+
+func (request *Request) CSRFToken() string { //获取当前请求的CSRF token，用来放进表单或页面里
+	return request.csrfToken
+}
+
+func (request *Request) CSRFField() template.HTML { //生成一个带token的隐藏表单字段，可以直接在html/template里用
+	return template.HTML(`<input type="hidden" name="` + html.EscapeString(request.csrfFieldName) + `" value="` + html.EscapeString(request.csrfToken) + `">`)
+}
+
+func checkCSRFOrigin(request *Request, trustedOrigins []string) error { //用Sec-Fetch-Site和Origin检查请求来源
+	origin, hasOrigin := request.Header.Lookup("Origin")
+	trusted := false
+	if hasOrigin {
+		if origin == request.origin() {
+			trusted = true
+		}
+		for i := 0; i < len(trustedOrigins); i++ {
+			if origin == trustedOrigins[i] {
+				trusted = true
+			}
+		}
+	}
+	if fetchSite, ok := request.Header.Lookup("Sec-Fetch-Site"); ok {
+		if fetchSite == "same-origin" || fetchSite == "none" || trusted {
+			return nil
+		}
+		return ErrCSRFOriginMismatch
+	}
+	if hasOrigin && !trusted {
+		return ErrCSRFOriginMismatch
+	}
+	return nil
+}
+
+func submittedCSRFToken(request *Request, fieldName string, headerName string) string { //依次从header、表单、URL参数里找token
+	if token := request.Header.Get(headerName); token != "" {
+		return token
+	}
+	if form, err := request.DecodeFormUrlEncoded(); err == nil {
+		if token, ok := form[fieldName]; ok {
+			return token
+		}
+	}
+	if request.UrlParameter != "" { //multipart上传不方便先读body，可以把token放在URL参数里
+		return request.DecodeUrlParameter()[fieldName]
+	}
+	return ""
+}
+
+func CSRFMiddleware(config CSRFConfig) Middleware { //CSRF中间件，非安全方法（POST等）的请求都要带上正确的token
+	if config.CookieName == "" {
+		config.CookieName = defaultCSRFCookieName
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.SameSite == "" {
+		config.SameSite = SameSiteLax
+	}
+	if config.FieldName == "" {
+		config.FieldName = defaultCSRFFieldName
+	}
+	if config.HeaderName == "" {
+		config.HeaderName = defaultCSRFHeaderName
+	}
+	if config.FailureHandler == nil {
+		config.FailureHandler = func(request *Request, err error) *Response {
+			return Build403Response()
+		}
+	}
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			if csrfPathExempt(request.Path, config.ExemptPaths) {
+				return next(request)
+			}
+
+			var token []byte
+			setCookie := false
+			if config.Mode == CSRFSynchronizerToken {
+				session := request.GetSession()
+				if session == nil {
+					panic(ErrCSRFNoSession)
+				}
+				if value, ok := session.Get(csrfSessionKey); ok {
+					token, _ = base64.RawURLEncoding.DecodeString(value)
+				}
+				if len(token) != csrfTokenLength {
+					token = generateRandomBytes(csrfTokenLength)
+					session.Set(csrfSessionKey, base64.RawURLEncoding.EncodeToString(token))
+				}
+			} else {
+				var value string
+				var err error
+				if config.Keys != nil {
+					value, err = request.GetSignedCookie(config.Keys, config.CookieName)
+				} else if cookieValue, ok := request.GetCookie(config.CookieName); ok {
+					value = cookieValue
+				} else {
+					err = ErrCookieNotFound
+				}
+				if err == nil {
+					token, _ = base64.RawURLEncoding.DecodeString(value)
+				}
+				if len(token) != csrfTokenLength {
+					token = generateRandomBytes(csrfTokenLength)
+					setCookie = true
+				}
+			}
+			request.csrfToken = maskCSRFToken(token)
+			request.csrfFieldName = config.FieldName
+
+			if !csrfSafeMethod(request.Method) {
+				if err := checkCSRFOrigin(request, config.TrustedOrigins); err != nil {
+					return config.FailureHandler(request, err)
+				}
+				submitted := submittedCSRFToken(request, config.FieldName, config.HeaderName)
+				if submitted == "" {
+					return config.FailureHandler(request, ErrCSRFTokenMissing)
+				}
+				if subtle.ConstantTimeCompare(unmaskCSRFToken(submitted), token) != 1 {
+					return config.FailureHandler(request, ErrCSRFTokenInvalid)
+				}
+			}
+
+			response := next(request)
+			if setCookie && !response.sendedHeader {
+				cookie := &Cookie{Name: config.CookieName, Value: base64.RawURLEncoding.EncodeToString(token), Path: config.CookiePath, Domain: config.CookieDomain, Secure: config.Secure, SameSite: config.SameSite}
+				if config.Keys != nil {
+					if err := response.SetSignedCookie(config.Keys, cookie); err != nil {
+						panic(err)
+					}
+				} else {
+					response.SetCookie(cookie)
+				}
+			}
+			return response
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package simpwebserv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCSRFTokenMasking(t *testing.T) {
+	token := generateRandomBytes(csrfTokenLength)
+	first, second := maskCSRFToken(token), maskCSRFToken(token)
+	if first == second {
+		t.Fatal("masked tokens should differ every time")
+	}
+	if !bytes.Equal(unmaskCSRFToken(first), token) || !bytes.Equal(unmaskCSRFToken(second), token) {
+		t.Fatal("unmasked token does not match")
+	}
+	for _, invalid := range []string{"", "!!", maskCSRFToken(token)[:10], maskCSRFToken(generateRandomBytes(csrfTokenLength + 1))} {
+		if unmaskCSRFToken(invalid) != nil {
+			t.Errorf("%q should not unmask", invalid)
+		}
+	}
+}
+
+func TestCheckCSRFOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		ok      bool
+	}{
+		{"no headers", nil, true},
+		{"same origin", map[string]string{"Origin": "http://example.com"}, true},
+		{"other origin", map[string]string{"Origin": "http://evil.com"}, false},
+		{"trusted origin", map[string]string{"Origin": "https://app.example.com"}, true},
+		{"fetch same-origin", map[string]string{"Sec-Fetch-Site": "same-origin"}, true},
+		{"fetch none", map[string]string{"Sec-Fetch-Site": "none"}, true},
+		{"fetch cross-site", map[string]string{"Sec-Fetch-Site": "cross-site"}, false},
+		{"fetch same-site", map[string]string{"Sec-Fetch-Site": "same-site"}, false},
+		{"fetch cross-site trusted", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://app.example.com"}, true},
+	}
+	for _, test := range tests {
+		request := newTestRequest(t, "POST", "/")
+		request.Header.Set("Host", "example.com")
+		for key, value := range test.headers {
+			request.Header.Set(key, value)
+		}
+		err := checkCSRFOrigin(request, []string{"https://app.example.com"})
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+func csrfTestHandler(called *bool) func(*Request) *Response {
+	return func(request *Request) *Response {
+		*called = true
+		response := BuildBasicResponse()
+		response.Body.WriteString(request.CSRFToken())
+		return response
+	}
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	for _, keys := range []*CookieKeys{nil, NewCookieKeys([]byte("csrf key"))} {
+		var called bool
+		handler := Chain(csrfTestHandler(&called), CSRFMiddleware(CSRFConfig{Mode: CSRFDoubleSubmitCookie, Keys: keys, ExemptPaths: []string{"/webhook/*"}}))
+
+		response := handler(newTestRequest(t, "GET", "/form"))
+		cookie := cookieHeaderFrom(response)
+		token := response.Body.String()
+		if !called || cookie == "" || token == "" {
+			t.Fatalf("GET should set a cookie and expose a token, got cookie %q token %q", cookie, token)
+		}
+
+		post := func(path string, setup func(*Request)) (*Response, bool) {
+			called = false
+			request := newTestRequest(t, "POST", path)
+			request.Header.Set("Host", "example.com")
+			request.Header.Set("Cookie", cookie)
+			setup(request)
+			return handler(request), called
+		}
+		if response, ok := post("/form", func(request *Request) { request.Header.Set("X-CSRF-Token", token) }); !ok || response.Code != "200" {
+			t.Errorf("header token: got %s", response.Code)
+		}
+		if response, ok := post("/form", func(request *Request) {}); ok || response.Code != "403" {
+			t.Errorf("missing token: got %s", response.Code)
+		}
+		if response, ok := post("/form", func(request *Request) {
+			request.Header.Set("X-CSRF-Token", maskCSRFToken(generateRandomBytes(csrfTokenLength)))
+		}); ok || response.Code != "403" {
+			t.Errorf("wrong token: got %s", response.Code)
+		}
+		if response, ok := post("/form", func(request *Request) {
+			request.Header.Set("X-CSRF-Token", token)
+			request.Header.Set("Origin", "http://evil.com")
+		}); ok || response.Code != "403" {
+			t.Errorf("cross origin: got %s", response.Code)
+		}
+		if response, ok := post("/form", func(request *Request) {
+			request.Header.Set("X-CSRF-Token", token)
+			request.Header.Del("Cookie")
+		}); ok || response.Code != "403" {
+			t.Errorf("no cookie: got %s", response.Code)
+		}
+		if response, ok := post("/webhook/github", func(request *Request) {}); !ok || response.Code != "200" {
+			t.Errorf("exempt path: got %s", response.Code)
+		}
+	}
+}
+
+func TestCSRFFormFieldKeepsFormForHandler(t *testing.T) {
+	var form map[string]string
+	handler := Chain(func(request *Request) *Response {
+		form, _ = request.DecodeFormUrlEncoded()
+		return BuildBasicResponse()
+	}, CSRFMiddleware(CSRFConfig{Mode: CSRFDoubleSubmitCookie}))
+
+	getResponse := handler(newTestRequest(t, "GET", "/"))
+	cookie := cookieHeaderFrom(getResponse)
+	token := strings.SplitN(strings.SplitN(cookie, "=", 2)[1], ";", 2)[0] //未掩码的cookie值也能通过
+
+	request := newTestRequest(t, "POST", "/")
+	request.Header.Set("Cookie", cookie)
+	setTestBody(request, "application/x-www-form-urlencoded; charset=utf-8", "name=a%20b&csrf_token="+token)
+	if response := handler(request); response.Code != "200" {
+		t.Fatalf("got %s", response.Code)
+	}
+	if form["name"] != "a b" {
+		t.Fatalf("handler should still see the form, got %v", form)
+	}
+}
+
+func TestCSRFSynchronizerNeedsSession(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrCSRFNoSession {
+			t.Fatalf("got %v, want ErrCSRFNoSession panic", r)
+		}
+	}()
+	Chain(csrfTestHandler(new(bool)), CSRFMiddleware(CSRFConfig{}))(newTestRequest(t, "GET", "/"))
+}
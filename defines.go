@@ -5,9 +5,39 @@ import "errors"
 const (
 	bufferMaxSize      = 1024
 	fileSendBufferSize = 4096
+	maxFormSize        = 10 << 20
 )
 
 var (
-	ErrBufferTooBig            = errors.New("buffer too big")
-	ErrRequirementNotSatisfied = errors.New("requirement not satisfied")
+	ErrBufferTooBig               = errors.New("buffer too big")
+	ErrRequirementNotSatisfied    = errors.New("requirement not satisfied")
+	ErrCookieNotFound             = errors.New("cookie not found")
+	ErrInvalidCookie              = errors.New("invalid cookie")
+	ErrCookieExpired              = errors.New("cookie expired")
+	ErrNoCookieKey                = errors.New("no cookie signing key")
+	ErrSessionTooLarge            = errors.New("session too large for cookie")
+	ErrCSRFNoSession              = errors.New("csrf synchronizer token mode requires SessionMiddleware")
+	ErrCSRFTokenMissing           = errors.New("csrf token missing")
+	ErrCSRFTokenInvalid           = errors.New("csrf token invalid")
+	ErrCSRFOriginMismatch         = errors.New("csrf origin mismatch")
+	ErrJWTMalformed               = errors.New("malformed token")
+	ErrJWTAlgorithm               = errors.New("unsupported token algorithm")
+	ErrJWTUnknownKey              = errors.New("unknown token key")
+	ErrJWTSignature               = errors.New("invalid token signature")
+	ErrJWTExpired                 = errors.New("token expired")
+	ErrJWTNotYetValid             = errors.New("token not yet valid")
+	ErrJWTIssuer                  = errors.New("invalid token issuer")
+	ErrJWTAudience                = errors.New("invalid token audience")
+	ErrJWKSFetchFailed            = errors.New("jwks fetch failed")
+	ErrNoCertificateFound         = errors.New("no certificate found")
+	ErrAcmeNoChallenge            = errors.New("no supported acme challenge")
+	ErrAddressInUse               = errors.New("address already in use")
+	ErrHandlerTimeout             = errors.New("handler timeout")
+	ErrNoListener                 = errors.New("no listener to serve on")
+	ErrShutdownTimeout            = errors.New("shutdown timeout, connections were closed forcibly")
+	ErrUpgradeFailed              = errors.New("new process did not become ready")
+	ErrUpgradeNotSupported        = errors.New("graceful upgrade not supported on this platform")
+	ErrHealthCheckTimeout         = errors.New("health check timeout")
+	ErrShuttingDown               = errors.New("server is shutting down")
+	ErrUnsupportedDigestAlgorithm = errors.New("unsupported digest algorithm")
 )
@@ -0,0 +1,170 @@
+package simpwebserv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultDevCertDir   = "devcert"
+	devCAValidity       = 10 * 365 * 24 * time.Hour
+	devLeafValidity     = 397 * 24 * time.Hour //浏览器接受的最长有效期
+	devLeafRenewBefore  = 30 * 24 * time.Hour
+	devCertOrganization = "simpwebserv development CA"
+)
+
+func writePemFile(path string, blockType string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), perm)
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func loadOrCreateDevCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) { //读取或生成本地开发用的CA
+	caPath := filepath.Join(dir, "ca.pem")
+	caKeyPath := filepath.Join(dir, "ca.key")
+	if pair, err := tls.LoadX509KeyPair(caPath, caKeyPath); err == nil {
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if key, ok := pair.PrivateKey.(*ecdsa.PrivateKey); err == nil && ok && time.Now().Before(cert.NotAfter) {
+			return cert, key, nil
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{devCertOrganization}, CommonName: "simpwebserv dev CA " + hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writePemFile(caKeyPath, "EC PRIVATE KEY", keyDer, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err = writePemFile(caPath, "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func devLeafUsable(pemPath string, keyPath string, ca *x509.Certificate, hosts []string) bool { //缓存的证书是否还能用（同一个CA签的、没快过期、包含所有主机名）
+	pair, err := tls.LoadX509KeyPair(pemPath, keyPath)
+	if err != nil {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil || leaf.CheckSignatureFrom(ca) != nil || time.Until(leaf.NotAfter) < devLeafRenewBefore {
+		return false
+	}
+	for i := 0; i < len(hosts); i++ {
+		if leaf.VerifyHostname(hosts[i]) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func generateDevCertificate(dir string, hosts []string) (string, string, string, error) { //生成开发用证书，返回证书路径、私钥路径和CA路径
+	if dir == "" {
+		dir = defaultDevCertDir
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", "", err
+	}
+	ca, caKey, err := loadOrCreateDevCA(dir)
+	if err != nil {
+		return "", "", "", err
+	}
+	caPath, _ := filepath.Abs(filepath.Join(dir, "ca.pem"))
+	pemPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "cert.key")
+	allHosts := []string{"localhost", "127.0.0.1", "::1"}
+	for i := 0; i < len(hosts); i++ {
+		if hosts[i] != "" && hosts[i] != "0.0.0.0" && hosts[i] != "::" && !containsFold(allHosts, hosts[i]) {
+			allHosts = append(allHosts, hosts[i])
+		}
+	}
+	if devLeafUsable(pemPath, keyPath, ca, allHosts) {
+		return pemPath, keyPath, caPath, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", "", err
+	}
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return "", "", "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{devCertOrganization}, CommonName: allHosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(devLeafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for i := 0; i < len(allHosts); i++ {
+		if ip := net.ParseIP(allHosts[i]); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, allHosts[i])
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", "", err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", "", err
+	}
+	if err = writePemFile(keyPath, "EC PRIVATE KEY", keyDer, 0600); err != nil {
+		return "", "", "", err
+	}
+	certPem := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	if err = os.WriteFile(pemPath, certPem, 0644); err != nil {
+		return "", "", "", err
+	}
+	return pemPath, keyPath, caPath, nil
+}
+
+func (app *AppStruct) SetDevTls(dir string, hosts ...string) error { //生成并使用开发用的自签名证书，需要把打印出来的CA加入系统信任
+	pemPath, keyPath, caPath, err := generateDevCertificate(dir, hosts)
+	if err != nil {
+		return err
+	}
+	log.Println("Development CA certificate: " + caPath + " (trust it to avoid browser warnings)")
+	return app.AddTlsCertificate(pemPath, keyPath)
+}
@@ -2,13 +2,18 @@ package simpwebserv
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/crypto/acme"
 )
 
 func PanicTrace() []byte {
@@ -33,17 +38,17 @@ func PanicTrace() []byte {
 	return stack
 }
 
-func getFunction(app *AppStruct, path string) (bool, func(*Request) *Response) { //按照url路径获取对应的函数
+func getFunction(app *AppStruct, path string) (bool, func(*Request) *Response, string) { //按照url路径获取对应的函数和注册时的路径
 	if path == "/" {
 		if app.urlRootNode.Function != nil {
-			return true, app.urlRootNode.Function
+			return true, app.urlRootNode.Function, app.urlRootNode.pattern
 		}
-		return false, nil
+		return false, nil, ""
 	}
 	pathSplit := strings.Split(path, "/")
 	pathSplit = pathSplit[1:]
 	if len(pathSplit) == 0 {
-		return false, nil
+		return false, nil, ""
 	}
 	if pathSplit[len(pathSplit)-1] == "" {
 		pathSplit = pathSplit[0 : len(pathSplit)-1]
@@ -54,12 +59,12 @@ func getFunction(app *AppStruct, path string) (bool, func(*Request) *Response) {
 			nowNode = v
 		} else {
 			if nowNode.IncludeBack {
-				return true, nowNode.Function
+				return true, nowNode.Function, nowNode.pattern
 			}
-			return false, nil
+			return false, nil, ""
 		}
 	}
-	return true, nowNode.Function
+	return true, nowNode.Function, nowNode.pattern
 }
 
 func httpReadHeaderLine(conn net.Conn, buffer *[]byte, recv *bytes.Buffer, first *string, second *string, err *error, i *int) { //读HTTP的一行header
@@ -120,18 +125,28 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 	var i64 int64
 	var first string
 	var second string
-	var found bool
-	var function func(*Request) *Response
 	var response *Response
 	var v string
 	var ok bool
+	var headerStart time.Time
+	var headerBytes int
+	var headerCount int
+	var pending []byte //检测断开连接时读到的下一个请求的数据
+	var requestStarted bool
+	var span *Span
 
 	defer func() { //错误处理
 		if r := recover(); r != nil {
+			if request.cancel != nil {
+				request.cancel()
+			}
 			response = Build500DefaultResponse()
 			if app.debugMode {
 				response.Body.Reset()
 				data := r.(error).Error() + "\n" + string(PanicTrace())
+				if request.requestID != "" {
+					data = "Request ID: " + request.requestID + "\n" + data
+				}
 				fmt.Println(data)
 				lineSplit := strings.Split(data, "\n")
 				response.Body.WriteString("<html><body>")
@@ -142,36 +157,83 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 				}
 				response.Body.WriteString("</body></html>")
 			}
-			response.Header["Content-length"] = strconv.Itoa(response.Body.Len())
+			if request.requestID != "" {
+				response.Header.Set(RequestIDHeader, request.requestID)
+			}
+			response.Header.Set("Content-Length", strconv.Itoa(response.Body.Len()))
 			request.SendHeader(response)
-			conn.Write(response.Body.Bytes())
+			request.ConnWrite(response.Body.Bytes())
 			if app.enableConsoleLog {
 				log.Println(request.Host + " " + request.Method + " " + request.Path + " " + response.Code + " " + response.CodeName)
 			}
+			app.logAccess(&request, response, headerStart)
+			if requestStarted {
+				app.metrics.finishRequest(&request, response, headerStart)
+			}
+			endServerSpan(span, &request, response)
 			conn.Close()
 		}
 	}()
 
-	for {
-		request = Request{conn, app.enableKeepAlive, []byte{}, app.keepAliveTimeout, 0, "", "", "", "", "", make(map[string]string), 0, 0}
+	app.connTracker.add(conn)
+	defer app.connTracker.remove(conn)
+	defer app.connLimiter.release(conn) //acceptConn里已经acquire过了
+
+	if tlsConn, ok := conn.(*tls.Conn); ok { //先握手，ACME的TLS-ALPN-01验证连接握手完就关掉
+		conn.SetReadDeadline(timeoutDeadline(app.readHeaderTimeout))
+		conn.SetWriteDeadline(timeoutDeadline(app.writeTimeout))
+		if err = tlsConn.Handshake(); err != nil || tlsConn.ConnectionState().NegotiatedProtocol == acme.ALPNProto {
+			conn.Close()
+			return
+		}
+	}
+
+	for firstRequest := true; ; firstRequest = false {
+		request = Request{conn, app.readTimeout, []byte{}, app.writeTimeout, 0, false, false, 0, "", "", app.minTransferRate, time.Time{}, "", "", "", "", "", make(Header), 0, nil, nil, "", "", "", nil, nil, nil, false, nil}
 		request.Host = conn.RemoteAddr().String()
 		buffer = make([]byte, 1)
 		i = 0
 		first = ""
 		second = ""
+		headerBytes = 0
+		headerCount = 0
 
-		if app.enableKeepAlive {
-			conn.SetReadDeadline(time.Now().Add(time.Second * app.keepAliveTimeout))
+		if !app.connTracker.setIdle(conn) { //正在平滑退出，不再等下一个请求
+			conn.Close()
+			return
+		}
+		if firstRequest {
+			conn.SetReadDeadline(timeoutDeadline(app.readHeaderTimeout))
+		} else {
+			conn.SetReadDeadline(timeoutDeadline(app.idleTimeout))
 		}
 		for { //读请求方法
-			i, err = conn.Read(buffer)
+			if len(pending) != 0 {
+				buffer[0] = pending[0]
+				pending = pending[1:]
+				i, err = 1, nil
+			} else {
+				i, err = conn.Read(buffer)
+			}
 			if err != nil {
-				conn.Close()
+				if recv.Len() == 0 { //空闲超时或者对方关闭，直接断开
+					conn.Close()
+				} else {
+					app.closeAfterReadError(&request, err)
+				}
 				return
 			}
 			if i == 0 {
 				continue
 			}
+			if recv.Len() == 0 {
+				if !app.connTracker.setActive(conn) {
+					conn.Close()
+					return
+				}
+				headerStart = time.Now()
+				conn.SetReadDeadline(app.headerDeadline(headerStart, 0)) //整个header要在readHeaderTimeout内读完，并且不能低于最低速率
+			}
 			if buffer[0] == ' ' {
 				request.Method = recv.String()
 				//fmt.Println(request.Method)
@@ -187,7 +249,7 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 		for { //读纯路径
 			i, err = conn.Read(buffer)
 			if err != nil {
-				conn.Close()
+				app.closeAfterReadError(&request, err)
 				return
 			}
 			if i == 0 {
@@ -203,7 +265,7 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 				for { //读URL传参
 					i, err = conn.Read(buffer)
 					if err != nil {
-						conn.Close()
+						app.closeAfterReadError(&request, err)
 						return
 					}
 					if i == 0 {
@@ -232,7 +294,7 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 		for { //读协议
 			i, err = conn.Read(buffer)
 			if err != nil {
-				conn.Close()
+				app.closeAfterReadError(&request, err)
 				return
 			}
 			if i == 0 {
@@ -242,10 +304,9 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 				request.Protocol = recv.String()
 				//fmt.Println(recv.Bytes())
 				recv.Reset()
-				if app.enableKeepAlive {
-					conn.SetReadDeadline(time.Now().Add(time.Second * app.keepAliveTimeout))
-				}
 				conn.Read(buffer)
+				headerBytes = len(request.Method) + len(request.Path) + len(request.UrlParameter) + len(request.Protocol) + 4
+				conn.SetReadDeadline(app.headerDeadline(headerStart, headerBytes))
 				break
 			}
 			recv.Write(buffer)
@@ -255,22 +316,26 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 		}
 
 		for { //读header
-			if app.enableKeepAlive {
-				conn.SetReadDeadline(time.Now().Add(time.Second * app.keepAliveTimeout))
-			}
 			httpReadHeaderLine(conn, &buffer, recv, &first, &second, &err, &i)
 			if err != nil {
-				conn.Close()
+				app.closeAfterReadError(&request, err)
 				return
 			}
 			//fmt.Println(first, second)
 			if first == "" || second == "" {
 				break
 			}
-			request.Header[first] = second
+			headerCount++
+			headerBytes += len(first) + len(second) + 3
+			if (app.maxHeaderCount > 0 && headerCount > app.maxHeaderCount) || (app.maxHeaderBytes > 0 && headerBytes > app.maxHeaderBytes) {
+				app.closeAfterReadError(&request, ErrBufferTooBig)
+				return
+			}
+			conn.SetReadDeadline(app.headerDeadline(headerStart, headerBytes))
+			request.Header.Add(first, strings.TrimSpace(second))
 		}
 
-		if v, ok = request.Header["Content-Length"]; ok { //获取content-length
+		if v, ok = request.Header.Lookup("Content-Length"); ok { //获取content-length
 			i64, err = strconv.ParseInt(v, 10, 64)
 			request.bodyBytes = uint64(i64)
 			if err != nil {
@@ -278,11 +343,31 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 			}
 		}
 
-		found, function = getFunction(app, request.Path)
-		if !found {
-			response = Build404Response()
+		request.ctx, request.cancel = context.WithCancel(app.baseContext)
+		if found, function, pattern := getFunction(app, request.Path); found && function != nil { //在中间件之前就定好路由，被中间件直接回复的请求也按路由统计
+			request.route = pattern
+		}
+		if app.metrics != nil {
+			app.metrics.startRequest(!firstRequest)
+			requestStarted = true
+		}
+		if app.tracer != nil {
+			span = app.startServerSpan(&request, headerStart)
+		}
+		if app.acmeManager != nil {
+			var isChallenge bool
+			if response, isChallenge = app.acmeManager.httpChallengeResponse(&request); !isChallenge {
+				response = app.handler(&request)
+			}
 		} else {
-			response = function(&request)
+			response = app.handler(&request)
+		}
+
+		pending = request.stopWatchDisconnect()
+
+		if request.readTimedOut && !response.sendedHeader { //处理函数读body超时
+			atomic.AddUint64(&app.connLimiter.stats.RequestTimeouts, 1)
+			response = Build408Response()
 		}
 
 		if app.enableConsoleLog {
@@ -290,36 +375,43 @@ func connectionHandler(conn net.Conn, app *AppStruct) { //处理请求
 		}
 
 		if !response.sendedHeader {
-			response.Header["Content-length"] = strconv.Itoa(response.Body.Len())
-			if app.enableKeepAlive {
-				response.Header["Connection"] = "keep-alive"
+			if app.hstsHeader != "" && request.IsTls() && !response.Header.Has("Strict-Transport-Security") {
+				response.Header.Set("Strict-Transport-Security", app.hstsHeader)
+			}
+			if responseHasBody(response.Code) {
+				response.Header.Set("Content-Length", strconv.Itoa(response.Body.Len()))
+			}
+			if app.enableKeepAlive && !app.connTracker.isShuttingDown() && !request.readTimedOut && !request.closeConn {
+				response.Header.Set("Connection", "keep-alive")
 			} else {
-				response.Header["Connection"] = "close"
+				response.Header.Set("Connection", "close")
 			}
 			request.SendHeader(response)
 		}
 
-		if request.Method != "HEAD" {
-			n, err = conn.Write(response.Body.Bytes())
-			if n != response.Body.Len() || err != nil {
-				conn.Close()
-				return
-			}
+		if request.Method != "HEAD" && responseHasBody(response.Code) {
+			n, err = request.ConnWrite(response.Body.Bytes())
+		}
+		app.logAccess(&request, response, headerStart)
+		if requestStarted {
+			app.metrics.finishRequest(&request, response, headerStart)
+			requestStarted = false
+		}
+		endServerSpan(span, &request, response)
+		span = nil
+		if request.Method != "HEAD" && (n != response.Body.Len() || err != nil) {
+			conn.Close()
+			return
 		}
 
-		if !app.enableKeepAlive {
+		if !app.enableKeepAlive || app.connTracker.isShuttingDown() || request.readTimedOut || request.closeConn {
 			break
 		}
 
-		if app.enableKeepAlive {
-			conn.SetReadDeadline(time.Now().Add(time.Second * app.keepAliveTimeout))
-		}
-		for request.bodyBytes > request.readedBytes { //清空body
-			i, err = request.ConnRead(buffer)
-			if uint64(i) != request.bodyBytes-request.readedBytes || err != nil {
-				conn.Close()
-				return
-			}
+		if err = request.discardBody(); err != nil { //清空body
+			conn.Close()
+			return
 		}
 	}
+	conn.Close()
 }
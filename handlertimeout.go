@@ -0,0 +1,147 @@
+package simpwebserv
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+type TimeoutConfig struct {
+	Timeout    time.Duration            //处理函数的最长运行时间，到了之后取消请求的context
+	StatusCode string                   //超时的响应码，503或504，默认503
+	Response   func(*Request) *Response //自定义超时的响应，为nil时使用默认页面
+}
+
+type timeoutConn struct { //超时之后处理函数对连接的读写都会失败，防止和超时响应混在一起
+	net.Conn
+	lock     sync.Mutex
+	timedOut bool
+	wrote    bool
+	reading  int //正在读连接的次数，超时时还有读没返回的话读到的数据会丢，只能断开
+}
+
+func (conn *timeoutConn) Read(buf []byte) (int, error) {
+	conn.lock.Lock()
+	if conn.timedOut {
+		conn.lock.Unlock()
+		return 0, ErrHandlerTimeout
+	}
+	conn.reading++
+	conn.lock.Unlock()
+	n, err := conn.Conn.Read(buf)
+	conn.lock.Lock()
+	conn.reading--
+	conn.lock.Unlock()
+	return n, err
+}
+
+func (conn *timeoutConn) Write(buf []byte) (int, error) { //写的时候一直拿着锁，超时时正在写的数据会先写完
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.timedOut {
+		return 0, ErrHandlerTimeout
+	}
+	conn.wrote = true
+	return conn.Conn.Write(buf)
+}
+
+func (conn *timeoutConn) Close() error { //连接由connectionHandler关闭
+	return nil
+}
+
+func (conn *timeoutConn) SetDeadline(t time.Time) error {
+	if err := conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return conn.SetWriteDeadline(t)
+}
+
+func (conn *timeoutConn) SetReadDeadline(t time.Time) error {
+	conn.lock.Lock()
+	timedOut := conn.timedOut
+	conn.lock.Unlock()
+	if timedOut {
+		return ErrHandlerTimeout
+	}
+	return conn.Conn.SetReadDeadline(t)
+}
+
+func (conn *timeoutConn) SetWriteDeadline(t time.Time) error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.timedOut {
+		return ErrHandlerTimeout
+	}
+	return conn.Conn.SetWriteDeadline(t)
+}
+
+func (conn *timeoutConn) unwrapConn() net.Conn {
+	return conn.Conn
+}
+
+func (conn *timeoutConn) expire() (bool, bool) { //标记为超时，返回处理函数是否已经写过数据、是否还在读连接
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.timedOut = true
+	return conn.wrote, conn.reading != 0
+}
+
+func buildTimeoutResponse(code string) *Response {
+	if code == "504" {
+		return Build504Response()
+	}
+	return Build503Response()
+}
+
+func TimeoutMiddleware(config TimeoutConfig) Middleware { //处理函数超时后取消context并回复503或504，之后处理函数返回的响应和对连接的写入都会被丢掉，处理函数已经写过数据、还在读连接或者请求有body时超时后连接会被关闭
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			parent := request.ctx
+			if parent == nil {
+				parent = context.Background()
+			}
+			ctx, cancel := context.WithTimeout(parent, config.Timeout)
+			defer cancel()
+
+			conn := &timeoutConn{Conn: request.conn}
+			inner := *request //处理函数用一份拷贝，超时后它的修改不会影响到原来的请求
+			inner.conn = conn
+			inner.ctx = ctx
+			inner.Header = request.Header.Clone()
+			done := make(chan *Response, 1)
+			panicChan := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						panicChan <- r
+					}
+				}()
+				done <- next(&inner)
+			}()
+
+			select {
+			case response := <-done:
+				inner.conn = request.conn
+				*request = inner
+				return response
+			case r := <-panicChan:
+				panic(r) //交给connectionHandler回复500
+			case <-ctx.Done():
+				wrote, reading := conn.expire()
+				if wrote { //已经开始发响应了，只能直接断开
+					request.conn.Close()
+				}
+				request.closeConn = wrote || reading || request.bodyBytes != 0 //不知道body读到哪了，也没法接着读下一个请求
+				if config.Response != nil {
+					return config.Response(request)
+				}
+				return buildTimeoutResponse(config.StatusCode)
+			}
+		}
+	}
+}
+
+func (app *AppStruct) RegisterTimeout(function func(*Request) *Response, path string, includeBack bool, timeout time.Duration) { //注册一个路径，处理函数超过timeout时回复503
+	app.Register(Chain(function, TimeoutMiddleware(TimeoutConfig{Timeout: timeout})), path, includeBack)
+}
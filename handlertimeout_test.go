@@ -0,0 +1,194 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTimeoutTestServer(t *testing.T, config TimeoutConfig, slow func(*Request) *Response) (*bufio.Reader, net.Conn) { ///slow超时，/fast马上返回，返回一个keep-alive的连接
+	app := App()
+	app.SetEnableKeepAlive(true)
+	app.Register(Chain(slow, TimeoutMiddleware(config)), "/slow", false)
+	app.Register(func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Body.WriteString("fast")
+		return response
+	}, "/fast", false)
+	conn, err := net.Dial("tcp", startTestServer(t, app))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return bufio.NewReader(conn), conn
+}
+
+func readTestResponse(t *testing.T, reader *bufio.Reader) (*http.Response, string) {
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(body)
+}
+
+func TestTimeoutBeforeWriteKeepsConnection(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	reader, conn := newTimeoutTestServer(t, TimeoutConfig{Timeout: 50 * time.Millisecond, StatusCode: "504"}, func(request *Request) *Response {
+		<-release
+		return BuildBasicResponse()
+	})
+	conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: a\r\n\r\n"))
+	response, _ := readTestResponse(t, reader)
+	if response.StatusCode != 504 || response.Close {
+		t.Fatalf("got %d, close %v", response.StatusCode, response.Close)
+	}
+	conn.Write([]byte("GET /fast HTTP/1.1\r\nHost: a\r\n\r\n"))
+	if response, body := readTestResponse(t, reader); response.StatusCode != 200 || body != "fast" {
+		t.Fatalf("the connection should still be usable, got %d %q", response.StatusCode, body)
+	}
+}
+
+func TestTimeoutWithBodyClosesConnection(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	reader, conn := newTimeoutTestServer(t, TimeoutConfig{Timeout: 50 * time.Millisecond}, func(request *Request) *Response {
+		<-release
+		return BuildBasicResponse()
+	})
+	conn.Write([]byte("POST /slow HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello"))
+	response, _ := readTestResponse(t, reader)
+	if response.StatusCode != 503 || !response.Close {
+		t.Fatalf("got %d, close %v", response.StatusCode, response.Close)
+	}
+	if _, err := reader.ReadByte(); err == nil { //没读的body还在缓冲区里，关闭时可能是RST而不是EOF
+		t.Fatal("the connection should be closed")
+	}
+}
+
+func TestTimeoutAfterSendHeaderClosesConnection(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	reader, conn := newTimeoutTestServer(t, TimeoutConfig{Timeout: 50 * time.Millisecond}, func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Header.Set("Content-Length", "100")
+		request.SendHeader(response)
+		<-release
+		request.ConnWrite(make([]byte, 100)) //超时之后写不出去
+		return response
+	})
+	conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: a\r\n\r\n"))
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 {
+		t.Fatalf("got %d, the handler's header was already sent", response.StatusCode)
+	}
+	if body, err := io.ReadAll(response.Body); err != io.ErrUnexpectedEOF || len(body) != 0 {
+		t.Fatalf("got %d bytes, %v, want the connection closed", len(body), err)
+	}
+}
+
+func TestTimeoutCustomResponseAndContext(t *testing.T) {
+	canceled := make(chan error, 1)
+	handler := Chain(func(request *Request) *Response {
+		<-request.Context().Done()
+		canceled <- request.Context().Err()
+		return BuildBasicResponse()
+	}, TimeoutMiddleware(TimeoutConfig{Timeout: 20 * time.Millisecond, Response: func(request *Request) *Response {
+		response := Build503Response()
+		response.Body.Reset()
+		response.Body.WriteString("busy")
+		return response
+	}}))
+	request := newTestRequest(t, "GET", "/")
+	if response := handler(request); response.Code != "503" || response.Body.String() != "busy" {
+		t.Fatalf("got %s %q", response.Code, response.Body.String())
+	}
+	select {
+	case err := <-canceled:
+		if err == nil {
+			t.Fatal("context should report why it was canceled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler's context was not canceled")
+	}
+}
+
+func TestTimeoutCopiesRequestBack(t *testing.T) {
+	handler := Chain(func(request *Request) *Response {
+		request.User = "alice"
+		request.Header.Set("X-Seen", "1")
+		return BuildBasicResponse()
+	}, TimeoutMiddleware(TimeoutConfig{Timeout: time.Second}))
+	request := newTestRequest(t, "GET", "/")
+	conn := request.conn
+	if response := handler(request); response.Code != "200" {
+		t.Fatalf("got %s", response.Code)
+	}
+	if request.User != "alice" || request.Header.Get("X-Seen") != "1" || request.conn != conn || request.closeConn {
+		t.Fatalf("changes made in time should be kept, got user %q", request.User)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	slow := Chain(func(request *Request) *Response {
+		request.User = "late"
+		<-release
+		return BuildBasicResponse()
+	}, TimeoutMiddleware(TimeoutConfig{Timeout: 20 * time.Millisecond}))
+	request = newTestRequest(t, "GET", "/")
+	if response := slow(request); response.Code != "503" || request.User != "" {
+		t.Fatalf("got %s, user %q; changes after the deadline must not leak", response.Code, request.User)
+	}
+}
+
+func TestTimeoutConnRejectsLateIO(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := &timeoutConn{Conn: server}
+	go client.Read(make([]byte, 1))
+	if _, err := conn.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if wrote, reading := conn.expire(); !wrote || reading {
+		t.Fatalf("got wrote %v reading %v", wrote, reading)
+	}
+	if _, err := conn.Write([]byte("x")); err != ErrHandlerTimeout {
+		t.Errorf("write: got %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != ErrHandlerTimeout {
+		t.Errorf("read: got %v", err)
+	}
+	if err := conn.SetDeadline(time.Now()); err != ErrHandlerTimeout {
+		t.Errorf("deadline: got %v", err)
+	}
+
+	reading := &timeoutConn{Conn: server}
+	go reading.Read(make([]byte, 1))
+	for {
+		reading.lock.Lock()
+		started := reading.reading != 0
+		reading.lock.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, inFlight := reading.expire(); !inFlight {
+		t.Error("a read still in flight should be reported")
+	}
+}
@@ -0,0 +1,103 @@
+package simpwebserv
+
+import (
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+type Header map[string][]string //key统一为规范形式（如Content-Type）的header
+
+func CanonicalHeaderKey(key string) string { //把header的key转成规范形式
+	return textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(key))
+}
+
+func (header Header) Get(key string) string { //获取第一个值，不存在返回空字符串
+	values := header[CanonicalHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (header Header) Lookup(key string) (string, bool) { //获取第一个值并返回是否存在
+	values, ok := header[CanonicalHeaderKey(key)]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+func (header Header) Values(key string) []string { //获取全部的值
+	return header[CanonicalHeaderKey(key)]
+}
+
+func validHeaderKey(key string) bool { //key里不能有控制字符、空格和冒号，否则写出去时能伪造别的header或拆开响应
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == ':' || key[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func (header Header) Add(key string, value string) { //追加一个值，key不合法时忽略
+	key = CanonicalHeaderKey(key)
+	if !validHeaderKey(key) {
+		return
+	}
+	header[key] = append(header[key], value)
+}
+
+func (header Header) Set(key string, value string) { //覆盖成单个值，key不合法时忽略
+	key = CanonicalHeaderKey(key)
+	if !validHeaderKey(key) {
+		return
+	}
+	header[key] = []string{value}
+}
+
+func (header Header) Del(key string) { //删除
+	delete(header, CanonicalHeaderKey(key))
+}
+
+func (header Header) Has(key string) bool { //是否存在
+	_, ok := header[CanonicalHeaderKey(key)]
+	return ok
+}
+
+func (header Header) Clone() Header { //深拷贝
+	newHeader := make(Header, len(header))
+	for k, v := range header {
+		newHeader[k] = append([]string(nil), v...)
+	}
+	return newHeader
+}
+
+func (header Header) SortedKeys() []string { //按字典序排好的key，用来保证写出顺序固定
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var headerValueReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+func (header Header) write(builder *strings.Builder) { //按固定顺序写出所有header行
+	for _, k := range header.SortedKeys() {
+		if !validHeaderKey(k) { //直接往map里写的key也要检查
+			continue
+		}
+		for _, v := range header[k] {
+			builder.WriteString(k)
+			builder.WriteString(": ")
+			builder.WriteString(headerValueReplacer.Replace(v))
+			builder.WriteString("\r\n")
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package simpwebserv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeaderRejectsInvalidKeys(t *testing.T) {
+	header := make(Header)
+	for _, key := range []string{"X-A\r\nSet-Cookie", "X-A\nX-B", "X-A: b", "X:A", "X A", "", "X-\x00A", "X-\xffA"} {
+		header.Set(key, "v")
+		header.Add(key, "v")
+	}
+	if len(header) != 0 {
+		t.Fatalf("invalid keys were stored: %q", header.SortedKeys())
+	}
+	header.Set(" x-request-id ", "a")
+	header.Add("X-Request-Id", "b")
+	if values := header.Values("x-request-id"); len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Fatalf("got %q", values)
+	}
+
+	header["Bad\r\nKey"] = []string{"v"} //绕过Set直接写map
+	header.Set("X-Value", "a\r\nSet-Cookie: b")
+	var builder strings.Builder
+	header.write(&builder)
+	if got := builder.String(); got != "X-Request-Id: a\r\nX-Request-Id: b\r\nX-Value: a  Set-Cookie: b\r\n" {
+		t.Fatalf("got %q", got)
+	}
+}
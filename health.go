@@ -0,0 +1,216 @@
+package simpwebserv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	HealthProbeLiveness  = 1 << iota //进程还活着，失败时编排系统会重启进程
+	HealthProbeReadiness             //可以接收流量，失败时编排系统会把它从负载均衡里摘掉
+)
+
+const (
+	defaultHealthCheckTimeout = 5 * time.Second
+	shutdownHealthCheckName   = "shutdown"
+)
+
+type HealthCheck struct { //一个命名的健康检查
+	Name     string
+	Check    func(ctx context.Context) error //返回nil为健康，ctx在Timeout后取消
+	Timeout  time.Duration                   //默认5秒
+	CacheFor time.Duration                   //结果缓存多久，0为每次探测都检查
+	Probes   int                             //用于哪些探针，HealthProbeLiveness和HealthProbeReadiness的组合，默认只用于readiness；/healthz包含所有检查
+}
+
+type HealthCheckResult struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"` //ok或fail
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+	Cached     bool      `json:"cached,omitempty"`
+}
+
+type HealthReport struct {
+	Status string              `json:"status"` //所有检查都通过时为ok，否则为fail
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+type healthCheckState struct {
+	HealthCheck
+	lock      sync.Mutex
+	result    HealthCheckResult
+	checkedAt time.Time
+	running   chan struct{} //正在检查时不为nil，同时来的探测共用一次检查
+}
+
+type healthRegistry struct {
+	lock   sync.RWMutex
+	checks map[string]*healthCheckState
+}
+
+func newHealthRegistry() *healthRegistry {
+	return &healthRegistry{checks: make(map[string]*healthCheckState)}
+}
+
+func (app *AppStruct) AddHealthCheck(check HealthCheck) { //注册健康检查，同名的会被替换
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+	if check.Probes == 0 {
+		check.Probes = HealthProbeReadiness
+	}
+	app.health.lock.Lock()
+	app.health.checks[check.Name] = &healthCheckState{HealthCheck: check}
+	app.health.lock.Unlock()
+}
+
+func (app *AppStruct) RemoveHealthCheck(name string) {
+	app.health.lock.Lock()
+	delete(app.health.checks, name)
+	app.health.lock.Unlock()
+}
+
+func (state *healthCheckState) execute(done chan struct{}) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), state.Timeout)
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errChan <- state.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errChan:
+	case <-ctx.Done(): //检查函数不理会ctx的话也不等它
+		err = ErrHealthCheckTimeout
+	}
+	result := HealthCheckResult{Name: state.Name, Status: "ok", DurationMs: float64(time.Since(start).Microseconds()) / 1000, CheckedAt: start}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	state.lock.Lock()
+	state.result = result
+	state.checkedAt = time.Now()
+	state.running = nil
+	state.lock.Unlock()
+	close(done)
+}
+
+func (state *healthCheckState) run(ctx context.Context) HealthCheckResult {
+	state.lock.Lock()
+	if state.CacheFor > 0 && !state.checkedAt.IsZero() && time.Since(state.checkedAt) < state.CacheFor {
+		result := state.result
+		state.lock.Unlock()
+		result.Cached = true
+		return result
+	}
+	running := state.running
+	if running == nil {
+		running = make(chan struct{})
+		state.running = running
+		go state.execute(running)
+	}
+	state.lock.Unlock()
+	select {
+	case <-running:
+		state.lock.Lock()
+		defer state.lock.Unlock()
+		return state.result
+	case <-ctx.Done(): //探测的连接断开了
+		return HealthCheckResult{Name: state.Name, Status: "fail", Error: ctx.Err().Error(), CheckedAt: time.Now()}
+	}
+}
+
+func (app *AppStruct) CheckHealth(ctx context.Context, probes int) HealthReport { //并行运行用于probes的检查，readiness还会在平滑退出时失败
+	app.health.lock.RLock()
+	var states []*healthCheckState
+	for _, state := range app.health.checks {
+		if state.Probes&probes != 0 {
+			states = append(states, state)
+		}
+	}
+	app.health.lock.RUnlock()
+
+	report := HealthReport{Status: "ok", Checks: make([]HealthCheckResult, len(states))}
+	var wait sync.WaitGroup
+	for i := 0; i < len(states); i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			report.Checks[i] = states[i].run(ctx)
+		}(i)
+	}
+	wait.Wait()
+	if probes&HealthProbeReadiness != 0 { //平滑退出时不再接收新流量，但进程还活着，所以liveness不受影响
+		result := HealthCheckResult{Name: shutdownHealthCheckName, Status: "ok", CheckedAt: time.Now()}
+		if app.connTracker.isShuttingDown() {
+			result.Status = "fail"
+			result.Error = ErrShuttingDown.Error()
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	sort.Slice(report.Checks, func(i, j int) bool {
+		return report.Checks[i].Name < report.Checks[j].Name
+	})
+	for i := 0; i < len(report.Checks); i++ {
+		if report.Checks[i].Status != "ok" {
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+func (app *AppStruct) HealthHandler(probes int) func(*Request) *Response { //返回输出JSON检查结果的处理函数，失败时回复503，可以注册到别的实例（比如管理端口）上
+	return func(request *Request) *Response {
+		report := app.CheckHealth(request.Context(), probes)
+		response := BuildBasicResponse()
+		if report.Status != "ok" {
+			response.Code = "503"
+			response.CodeName = "Service Unavailable"
+		}
+		body, _ := json.Marshal(report)
+		response.Header.Set("Content-Type", "application/json")
+		response.Header.Set("Cache-Control", "no-store")
+		response.Body.Write(body)
+		response.Body.WriteByte('\n')
+		return response
+	}
+}
+
+func (app *AppStruct) RegisterHealthEndpoints(target *AppStruct) { //在target上注册/healthz、/readyz和/livez，target为nil时注册到自己上
+	if target == nil {
+		target = app
+	}
+	target.Register(app.HealthHandler(HealthProbeLiveness|HealthProbeReadiness), "/healthz", false)
+	target.Register(app.HealthHandler(HealthProbeReadiness), "/readyz", false)
+	target.Register(app.HealthHandler(HealthProbeLiveness), "/livez", false)
+}
+
+func (app *AppStruct) newAdminApp(config Config) *AppStruct { //创建管理端口的明文实例，提供健康检查和监控指标，连接数不和主实例一起算
+	adminApp := App()
+	adminApp.enableConsoleLog = false
+	adminApp.enableKeepAlive = app.enableKeepAlive
+	adminApp.idleTimeout = app.idleTimeout
+	adminApp.readHeaderTimeout = app.readHeaderTimeout
+	adminApp.readTimeout = app.readTimeout
+	adminApp.writeTimeout = app.writeTimeout
+	if config.HealthEndpoints {
+		app.RegisterHealthEndpoints(adminApp)
+	}
+	if config.MetricsPath != "" {
+		adminApp.Register(app.MetricsHandler(), config.MetricsPath, false)
+	}
+	return adminApp
+}
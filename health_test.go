@@ -0,0 +1,108 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadinessFailsDuringShutdown(t *testing.T) {
+	app := App()
+	app.enableConsoleLog = false
+	admin := App()
+	admin.SetEnableKeepAlive(true)
+	app.RegisterHealthEndpoints(admin)
+	app.AddHealthCheck(HealthCheck{Name: "db", Check: func(ctx context.Context) error { return nil }, Probes: HealthProbeLiveness | HealthProbeReadiness})
+	client := servePipe(t, admin)
+	reader := bufio.NewReader(client)
+	probe := func(path string) (int, HealthReport) {
+		go client.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: a\r\n\r\n"))
+		response, body := readTestResponse(t, reader)
+		var report HealthReport
+		if err := json.Unmarshal([]byte(body), &report); err != nil {
+			t.Fatal(err)
+		}
+		return response.StatusCode, report
+	}
+
+	if code, report := probe("/readyz"); code != 200 || report.Status != "ok" || len(report.Checks) != 2 {
+		t.Fatalf("got %d %+v before shutdown", code, report)
+	}
+	if err := app.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	code, report := probe("/readyz")
+	if code != 503 || report.Status != "fail" {
+		t.Fatalf("got %d %+v, want 503 once shutdown started", code, report)
+	}
+	for _, check := range report.Checks {
+		if (check.Name == shutdownHealthCheckName) != (check.Status == "fail") {
+			t.Fatalf("only the shutdown check should fail: %+v", report.Checks)
+		}
+	}
+	if code, _ := probe("/livez"); code != 200 {
+		t.Fatalf("liveness got %d during shutdown, want 200", code)
+	}
+}
+
+func TestHealthCheckCacheFor(t *testing.T) {
+	app := App()
+	var cachedCalls, uncachedCalls int32
+	app.AddHealthCheck(HealthCheck{Name: "cached", CacheFor: time.Hour, Check: func(ctx context.Context) error {
+		atomic.AddInt32(&cachedCalls, 1)
+		return nil
+	}})
+	app.AddHealthCheck(HealthCheck{Name: "uncached", Check: func(ctx context.Context) error {
+		atomic.AddInt32(&uncachedCalls, 1)
+		return nil
+	}})
+	for i := 0; i < 3; i++ {
+		report := app.CheckHealth(context.Background(), HealthProbeReadiness)
+		if report.Status != "ok" {
+			t.Fatalf("got %+v", report)
+		}
+		if i > 0 && !report.Checks[0].Cached {
+			t.Fatalf("probe %d: the cached check should report Cached", i)
+		}
+	}
+	if cachedCalls != 1 || uncachedCalls != 3 {
+		t.Fatalf("cached check ran %d times, uncached %d times, want 1 and 3", cachedCalls, uncachedCalls)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	app := App()
+	release := make(chan struct{})
+	defer close(release)
+	var sawDeadline int32
+	app.AddHealthCheck(HealthCheck{Name: "ignores-ctx", Timeout: 50 * time.Millisecond, Probes: HealthProbeLiveness, Check: func(ctx context.Context) error {
+		<-release
+		return nil
+	}})
+	app.AddHealthCheck(HealthCheck{Name: "uses-ctx", Timeout: 50 * time.Millisecond, Probes: HealthProbeLiveness, Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		atomic.StoreInt32(&sawDeadline, 1)
+		return ctx.Err()
+	}})
+	start := time.Now()
+	report := app.CheckHealth(context.Background(), HealthProbeLiveness)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("check took %v, the timeout should stop waiting after 50ms", elapsed)
+	}
+	if report.Status != "fail" || len(report.Checks) != 2 {
+		t.Fatalf("got %+v", report)
+	}
+	for _, check := range report.Checks {
+		if check.Status != "fail" || (check.Name == "ignores-ctx" && check.Error != ErrHealthCheckTimeout.Error()) {
+			t.Fatalf("got %+v", check)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&sawDeadline) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the check should see its context cancelled after Timeout")
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package simpwebserv
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestRequest(t *testing.T, method string, path string) *Request { //不经过connectionHandler构造一个请求，conn是内存管道
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return &Request{conn: server, readRestData: []byte{}, Method: method, Path: path, Protocol: "HTTP/1.1", Header: make(Header)}
+}
+
+func setTestBody(request *Request, contentType string, body string) { //把body放进readRestData，ConnRead会先读它
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	request.readRestData = []byte(body)
+	request.bodyBytes = uint64(len(body))
+	request.bodyReaded = uint64(len(body))
+}
+
+func cookieHeaderFrom(response *Response) string { //把响应的Set-Cookie转成下一个请求的Cookie header
+	pairs := make([]string, 0, len(response.SetCookieList))
+	for _, setCookie := range response.SetCookieList {
+		pairs = append(pairs, strings.SplitN(setCookie, ";", 2)[0])
+	}
+	return strings.Join(pairs, "; ")
+}
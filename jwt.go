@@ -0,0 +1,436 @@
+package simpwebserv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWTClockSkew        = 30 * time.Second
+	defaultJWKSRefreshInterval = time.Hour
+	minJWKSRefreshInterval     = time.Minute      //两次获取之间至少隔这么久，不认识的kid也不会更频繁地刷新
+	maxJWKSRefreshBackoff      = 30 * time.Minute //获取失败时间隔翻倍，最多到这么久
+	maxJWKSSize                = 1 << 20
+)
+
+type JWKS struct { //JSON Web Key Set，可以从文件或URL加载
+	lock            sync.RWMutex
+	keys            map[string]interface{}
+	url             string
+	refreshInterval time.Duration
+	lastFetch       time.Time //上次成功获取的时间
+	lastAttempt     time.Time //上次尝试获取的时间，失败了也会更新
+	failures        int       //连续失败的次数
+	refreshing      bool      //同时只有一个请求去刷新
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func decodeJWKBigInt(data string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func parseJSONWebKey(key jsonWebKey) (interface{}, error) { //把一个JWK转成对应的公钥
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeJWKBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if key.Crv != "P-256" {
+			return nil, ErrJWTAlgorithm
+		}
+		x, err := decodeJWKBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, ErrJWTAlgorithm
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrJWTMalformed
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(key.K)
+	}
+	return nil, ErrJWTAlgorithm
+}
+
+func parseJWKSKeys(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{})
+	for i := 0; i < len(set.Keys); i++ {
+		if set.Keys[i].Use != "" && set.Keys[i].Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(set.Keys[i])
+		if err != nil {
+			continue //不认识的key直接跳过
+		}
+		keys[set.Keys[i].Kid] = key
+	}
+	return keys, nil
+}
+
+func ParseJWKS(data []byte) (*JWKS, error) { //从JSON解析JWKS
+	keys, err := parseJWKSKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	return &JWKS{keys: keys}, nil
+}
+
+func LoadJWKSFile(path string) (*JWKS, error) { //从文件加载JWKS
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func NewJWKSFromURL(url string, refreshInterval time.Duration) (*JWKS, error) { //从URL加载JWKS，每refreshInterval或遇到不认识的kid时重新获取，同时只有一个请求去获取，失败时逐渐拉长重试间隔
+	if refreshInterval == 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+	jwks := &JWKS{keys: make(map[string]interface{}), url: url, refreshInterval: refreshInterval}
+	if err := jwks.Refresh(); err != nil {
+		return nil, err
+	}
+	return jwks, nil
+}
+
+func (jwks *JWKS) Refresh() error { //重新从URL获取
+	if jwks.url == "" {
+		return nil
+	}
+	jwks.lock.Lock()
+	jwks.lastAttempt = time.Now()
+	jwks.lock.Unlock()
+	keys, err := jwks.fetch()
+	jwks.lock.Lock()
+	defer jwks.lock.Unlock()
+	if err != nil {
+		jwks.failures++
+		return err
+	}
+	jwks.keys = keys
+	jwks.lastFetch = time.Now()
+	jwks.failures = 0
+	return nil
+}
+
+func (jwks *JWKS) fetch() (map[string]interface{}, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(jwks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrJWKSFetchFailed
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKSKeys(data)
+}
+
+func (jwks *JWKS) retryDelay() time.Duration { //距离上次尝试至少要隔多久，调用时要持有锁
+	delay := minJWKSRefreshInterval
+	for i := 1; i < jwks.failures && delay < maxJWKSRefreshBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxJWKSRefreshBackoff {
+		delay = maxJWKSRefreshBackoff
+	}
+	return delay
+}
+
+func (jwks *JWKS) refreshIfDue() bool { //没有别的请求在刷新并且过了重试间隔时刷新，返回是否刷新成功
+	jwks.lock.Lock()
+	if jwks.refreshing || time.Since(jwks.lastAttempt) < jwks.retryDelay() {
+		jwks.lock.Unlock()
+		return false
+	}
+	jwks.refreshing = true
+	jwks.lock.Unlock()
+	err := jwks.Refresh()
+	jwks.lock.Lock()
+	jwks.refreshing = false
+	jwks.lock.Unlock()
+	return err == nil
+}
+
+func (jwks *JWKS) lookup(kid string) (interface{}, bool) {
+	jwks.lock.RLock()
+	key, ok := jwks.keys[kid]
+	stale := jwks.url != "" && time.Since(jwks.lastFetch) > jwks.refreshInterval
+	jwks.lock.RUnlock()
+	if ok {
+		if stale { //过期了也先用缓存的key，在后台刷新
+			go jwks.refreshIfDue()
+		}
+		return key, true
+	}
+	if jwks.url != "" && jwks.refreshIfDue() { //不认识的kid可能是刚轮换的key，同步刷新一次
+		jwks.lock.RLock()
+		key, ok = jwks.keys[kid]
+		jwks.lock.RUnlock()
+	}
+	return key, ok
+}
+
+type JWTConfig struct { //JWT中间件的配置
+	Keys       map[string]interface{} //kid到密钥的映射，HS256用[]byte，RS256用*rsa.PublicKey，ES256用*ecdsa.PublicKey，EdDSA用ed25519.PublicKey
+	JWKS       *JWKS
+	Algorithms []string //允许的算法，为空时允许HS256、RS256、ES256、EdDSA
+	Issuer     string   //不为空时检查iss
+	Audience   string   //不为空时检查aud
+	ClockSkew  time.Duration
+	CookieName string //Authorization里没有token时从这个cookie里找
+	QueryParam string //再找不到就从这个URL参数里找
+}
+
+func verifyJWTSignature(algorithm string, key interface{}, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch algorithm {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrJWTAlgorithm
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrJWTSignature
+		}
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrJWTAlgorithm
+		}
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return ErrJWTSignature
+		}
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrJWTAlgorithm
+		}
+		if len(signature) != 64 || !ecdsa.Verify(publicKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return ErrJWTSignature
+		}
+	case "EdDSA":
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrJWTAlgorithm
+		}
+		if !ed25519.Verify(publicKey, []byte(signingInput), signature) {
+			return ErrJWTSignature
+		}
+	default:
+		return ErrJWTAlgorithm
+	}
+	return nil
+}
+
+func jwtNumericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false, ErrJWTMalformed
+	}
+	return time.Unix(int64(number), 0), true, nil
+}
+
+func VerifyJWT(token string, config JWTConfig) (map[string]interface{}, error) { //验证JWT并返回claims
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = []string{"HS256", "RS256", "ES256", "EdDSA"}
+	}
+	if config.ClockSkew == 0 {
+		config.ClockSkew = defaultJWTClockSkew
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err = json.Unmarshal(headerData, &header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	allowed := false
+	for i := 0; i < len(config.Algorithms); i++ {
+		if config.Algorithms[i] == header.Alg {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, ErrJWTAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	var candidates []interface{} //有kid就只用对应的key，没有就挨个试
+	if key, ok := config.Keys[header.Kid]; ok {
+		candidates = append(candidates, key)
+	} else if config.JWKS != nil {
+		if key, ok := config.JWKS.lookup(header.Kid); ok {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 && header.Kid == "" {
+		for _, key := range config.Keys {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrJWTUnknownKey
+	}
+	err = ErrJWTSignature
+	for i := 0; i < len(candidates) && err != nil; i++ {
+		err = verifyJWTSignature(header.Alg, candidates[i], parts[0]+"."+parts[1], signature)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	claims := make(map[string]interface{})
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	now := time.Now()
+	if exp, ok, err := jwtNumericDate(claims, "exp"); err != nil {
+		return nil, err
+	} else if ok && now.After(exp.Add(config.ClockSkew)) {
+		return nil, ErrJWTExpired
+	}
+	if nbf, ok, err := jwtNumericDate(claims, "nbf"); err != nil {
+		return nil, err
+	} else if ok && now.Add(config.ClockSkew).Before(nbf) {
+		return nil, ErrJWTNotYetValid
+	}
+	if config.Issuer != "" {
+		if issuer, _ := claims["iss"].(string); issuer != config.Issuer {
+			return nil, ErrJWTIssuer
+		}
+	}
+	if config.Audience != "" {
+		found := false
+		switch audience := claims["aud"].(type) {
+		case string:
+			found = audience == config.Audience
+		case []interface{}:
+			for i := 0; i < len(audience); i++ {
+				if s, ok := audience[i].(string); ok && s == config.Audience {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, ErrJWTAudience
+		}
+	}
+	return claims, nil
+}
+
+func (request *Request) BearerToken() (string, bool) { //获取Authorization里的Bearer token
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(authorization[7:])
+	return token, token != ""
+}
+
+func JWTMiddleware(config JWTConfig) Middleware { //JWT中间件，通过后用request.Claims拿到claims，request.User为sub
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			token, ok := request.BearerToken()
+			if !ok && config.CookieName != "" {
+				token, ok = request.GetCookie(config.CookieName)
+			}
+			if !ok && config.QueryParam != "" && request.UrlParameter != "" {
+				token, ok = request.DecodeUrlParameter()[config.QueryParam]
+			}
+			if !ok || token == "" {
+				return buildUnauthorizedResponse("Bearer")
+			}
+			claims, err := VerifyJWT(token, config)
+			if err != nil {
+				return buildUnauthorizedResponse(`Bearer error="invalid_token", error_description=` + quoteAuthParam(err.Error()))
+			}
+			request.Claims = claims
+			if subject, ok := claims["sub"].(string); ok {
+				request.User = subject
+			}
+			return next(request)
+		}
+	}
+}
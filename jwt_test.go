@@ -0,0 +1,366 @@
+package simpwebserv
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, header map[string]interface{}, claims map[string]interface{}, key interface{}) string { //按header里的alg手动签一个token
+	headerData, _ := json.Marshal(header)
+	claimsData, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(headerData) + "." + base64.RawURLEncoding.EncodeToString(claimsData)
+	digest := sha256.Sum256([]byte(signingInput))
+	var signature []byte
+	switch header["alg"] {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case "RS256":
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case "EdDSA":
+		signature = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signingInput))
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWTAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("hmac secret")
+	config := JWTConfig{Keys: map[string]interface{}{"hs": secret, "rs": &rsaKey.PublicKey, "es": &ecKey.PublicKey, "ed": edPublic}}
+	tests := []struct {
+		alg string
+		kid string
+		key interface{}
+	}{
+		{"HS256", "hs", secret},
+		{"RS256", "rs", rsaKey},
+		{"ES256", "es", ecKey},
+		{"EdDSA", "ed", edPrivate},
+	}
+	for _, test := range tests {
+		token := signTestJWT(t, map[string]interface{}{"alg": test.alg, "kid": test.kid}, map[string]interface{}{"sub": "alice"}, test.key)
+		claims, err := VerifyJWT(token, config)
+		if err != nil || claims["sub"] != "alice" {
+			t.Errorf("%s: got %v, %v", test.alg, claims, err)
+		}
+		parts := strings.Split(token, ".")
+		forged := signTestJWT(t, map[string]interface{}{"alg": test.alg, "kid": test.kid}, map[string]interface{}{"sub": "mallory"}, test.key)
+		if _, err := VerifyJWT(parts[0]+"."+strings.Split(forged, ".")[1]+"."+parts[2], config); err != ErrJWTSignature {
+			t.Errorf("%s swapped payload: got %v, want ErrJWTSignature", test.alg, err)
+		}
+		if _, err := VerifyJWT(token, JWTConfig{Keys: config.Keys, Algorithms: []string{"HS256"}}); test.alg != "HS256" && err != ErrJWTAlgorithm {
+			t.Errorf("%s not allowed: got %v, want ErrJWTAlgorithm", test.alg, err)
+		}
+	}
+}
+
+func TestVerifyJWTAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := JWTConfig{Keys: map[string]interface{}{"rs": &rsaKey.PublicKey}}
+	//用公钥的模数当HMAC密钥签名，不能被当作RS256的key接受
+	token := signTestJWT(t, map[string]interface{}{"alg": "HS256", "kid": "rs"}, map[string]interface{}{"sub": "mallory"}, rsaKey.PublicKey.N.Bytes())
+	if _, err := VerifyJWT(token, config); err != ErrJWTAlgorithm {
+		t.Errorf("HS256 with RSA key: got %v, want ErrJWTAlgorithm", err)
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "."
+	if _, err := VerifyJWT(none, config); err != ErrJWTAlgorithm {
+		t.Errorf("alg none: got %v, want ErrJWTAlgorithm", err)
+	}
+}
+
+func TestVerifyJWTKeySelection(t *testing.T) {
+	secret := []byte("hmac secret")
+	claims := map[string]interface{}{"sub": "alice"}
+	config := JWTConfig{Keys: map[string]interface{}{"a": []byte("other secret"), "b": secret}}
+	if _, err := VerifyJWT(signTestJWT(t, map[string]interface{}{"alg": "HS256"}, claims, secret), config); err != nil {
+		t.Errorf("no kid should try every key, got %v", err)
+	}
+	if _, err := VerifyJWT(signTestJWT(t, map[string]interface{}{"alg": "HS256", "kid": "c"}, claims, secret), config); err != ErrJWTUnknownKey {
+		t.Errorf("unknown kid: got %v, want ErrJWTUnknownKey", err)
+	}
+	if _, err := VerifyJWT(signTestJWT(t, map[string]interface{}{"alg": "HS256", "kid": "a"}, claims, secret), config); err != ErrJWTSignature {
+		t.Errorf("wrong kid: got %v, want ErrJWTSignature", err)
+	}
+}
+
+func TestVerifyJWTClaims(t *testing.T) {
+	secret := []byte("hmac secret")
+	now := time.Now().Unix()
+	config := JWTConfig{Keys: map[string]interface{}{"": secret}, Issuer: "https://issuer.example.com", Audience: "api"}
+	valid := map[string]interface{}{"iss": "https://issuer.example.com", "aud": "api"}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := make(map[string]interface{})
+		for key, value := range valid {
+			claims[key] = value
+		}
+		claims[name] = value
+		return claims
+	}
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{"valid", valid, nil},
+		{"expired", with("exp", now-3600), ErrJWTExpired},
+		{"expired within skew", with("exp", now-10), nil},
+		{"not yet valid", with("nbf", now+3600), ErrJWTNotYetValid},
+		{"nbf within skew", with("nbf", now+10), nil},
+		{"exp not a number", with("exp", "tomorrow"), ErrJWTMalformed},
+		{"wrong issuer", with("iss", "https://evil.com"), ErrJWTIssuer},
+		{"audience list", with("aud", []string{"web", "api"}), nil},
+		{"wrong audience", with("aud", []string{"web"}), ErrJWTAudience},
+		{"no audience", with("aud", nil), ErrJWTAudience},
+	}
+	for _, test := range tests {
+		token := signTestJWT(t, map[string]interface{}{"alg": "HS256"}, test.claims, secret)
+		if _, err := VerifyJWT(token, config); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestVerifyJWTMalformed(t *testing.T) {
+	config := JWTConfig{Keys: map[string]interface{}{"": []byte("hmac secret")}}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`))
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!!.e30.", "bm90IGpzb24.e30.", header + ".e30.!!"} {
+		if _, err := VerifyJWT(token, config); err != ErrJWTMalformed {
+			t.Errorf("%q: got %v, want ErrJWTMalformed", token, err)
+		}
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	keys := []map[string]string{
+		{"kty": "RSA", "kid": "rs", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": encode(ecKey.X.Bytes()), "y": encode(ecKey.Y.Bytes())},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": encode(edPublic)},
+		{"kty": "oct", "kid": "hs", "k": encode([]byte("hmac secret"))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": encode(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+		{"kty": "OKP", "kid": "short", "crv": "Ed25519", "x": "AA"},
+	}
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	jwks, err := ParseJWKS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kid := range []string{"enc", "p384", "short"} {
+		if _, ok := jwks.lookup(kid); ok {
+			t.Errorf("%s should be skipped", kid)
+		}
+	}
+	config := JWTConfig{JWKS: jwks}
+	tokens := map[string]string{
+		"rs": signTestJWT(t, map[string]interface{}{"alg": "RS256", "kid": "rs"}, map[string]interface{}{"sub": "alice"}, rsaKey),
+		"es": signTestJWT(t, map[string]interface{}{"alg": "ES256", "kid": "es"}, map[string]interface{}{"sub": "alice"}, ecKey),
+		"ed": signTestJWT(t, map[string]interface{}{"alg": "EdDSA", "kid": "ed"}, map[string]interface{}{"sub": "alice"}, edPrivate),
+		"hs": signTestJWT(t, map[string]interface{}{"alg": "HS256", "kid": "hs"}, map[string]interface{}{"sub": "alice"}, []byte("hmac secret")),
+	}
+	for kid, token := range tokens {
+		if _, err := VerifyJWT(token, config); err != nil {
+			t.Errorf("%s: got %v", kid, err)
+		}
+	}
+	if _, err := ParseJWKS([]byte("not json")); err == nil {
+		t.Error("invalid JSON should fail")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := map[string]string{
+		"Bearer abc.def.ghi":   "abc.def.ghi",
+		"bearer  abc.def.ghi ": "abc.def.ghi",
+		"Bearer ":              "",
+		"Basic YWxpY2U6cHc=":   "",
+		"":                     "",
+	}
+	for header, want := range tests {
+		request := newTestRequest(t, "GET", "/")
+		request.Header.Set("Authorization", header)
+		if token, ok := request.BearerToken(); token != want || ok != (want != "") {
+			t.Errorf("%q: got %q, %v", header, token, ok)
+		}
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	secret := []byte("hmac secret")
+	handler := Chain(func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Body.WriteString(request.User)
+		return response
+	}, JWTMiddleware(JWTConfig{Keys: map[string]interface{}{"": secret}, CookieName: "token", QueryParam: "access_token"}))
+	token := signTestJWT(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "alice"}, secret)
+
+	sources := map[string]func(*Request){
+		"header": func(request *Request) { request.Header.Set("Authorization", "Bearer "+token) },
+		"cookie": func(request *Request) { request.Header.Set("Cookie", "token="+token) },
+		"query":  func(request *Request) { request.UrlParameter = "access_token=" + token },
+	}
+	for name, setup := range sources {
+		request := newTestRequest(t, "GET", "/")
+		setup(request)
+		if response := handler(request); response.Code != "200" || response.Body.String() != "alice" {
+			t.Errorf("%s: got %s %q", name, response.Code, response.Body.String())
+		}
+	}
+
+	response := handler(newTestRequest(t, "GET", "/"))
+	if response.Code != "401" || response.Header.Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("no token: got %s %q", response.Code, response.Header.Get("WWW-Authenticate"))
+	}
+	request := newTestRequest(t, "GET", "/")
+	request.Header.Set("Authorization", "Bearer "+token[:len(token)-2]+"AA")
+	response = handler(request)
+	if response.Code != "401" || !strings.Contains(response.Header.Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Errorf("bad token: got %s %q", response.Code, response.Header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestJWKSRefreshBackoff(t *testing.T) {
+	var lock sync.Mutex
+	var requests int
+	failing := false
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		fail := failing
+		lock.Unlock()
+		if fail {
+			<-release //慢的失败请求，并发的lookup不能再发新的请求
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"keys":[{"kty":"oct","kid":"hs","k":"c2VjcmV0"}]}`))
+	}))
+	defer server.Close()
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return requests
+	}
+
+	jwks, err := NewJWKSFromURL(server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	failing = true
+	lock.Unlock()
+	if _, ok := jwks.lookup("unknown"); ok || count() != 1 {
+		t.Fatalf("unknown kid right after a fetch should not refetch, got %d requests", count())
+	}
+
+	jwks.lock.Lock()
+	jwks.lastAttempt = time.Now().Add(-2 * minJWKSRefreshInterval)
+	jwks.lock.Unlock()
+	var wait sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			jwks.lookup("random-" + strconv.Itoa(i))
+		}(i)
+	}
+	for count() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wait.Wait()
+	if count() != 2 {
+		t.Fatalf("concurrent lookups should share one fetch, got %d requests", count())
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, ok := jwks.lookup("random"); ok {
+			t.Fatal("unknown kid found")
+		}
+	}
+	if count() != 2 {
+		t.Fatalf("a failed fetch should back off, got %d requests", count())
+	}
+	jwks.lock.Lock()
+	jwks.lastAttempt = time.Now().Add(-90 * time.Second) //失败一次后要等1分钟
+	jwks.lock.Unlock()
+	jwks.lookup("random")
+	jwks.lookup("random")
+	if count() != 3 {
+		t.Fatalf("got %d requests after the first backoff, want 3", count())
+	}
+	jwks.lock.Lock()
+	jwks.lastAttempt = time.Now().Add(-90 * time.Second) //失败两次后要等2分钟
+	jwks.lock.Unlock()
+	jwks.lookup("random")
+	if count() != 3 {
+		t.Fatalf("the backoff should double, got %d requests", count())
+	}
+
+	jwks.lock.Lock()
+	jwks.lastFetch = time.Now().Add(-2 * time.Hour)
+	jwks.lastAttempt = time.Time{}
+	jwks.lock.Unlock()
+	if _, ok := jwks.lookup("hs"); !ok {
+		t.Fatal("stale keys should still be served while the provider is down")
+	}
+	for count() != 4 { //在后台刷新
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,136 @@
+package simpwebserv
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxHeaderCount = 100
+	defaultMaxHeaderBytes = 64 << 10
+	minTransferRateGrace  = 5 * time.Second //按最低速率计算时额外给的时间，防止刚开始传输就被断开
+)
+
+type ConnStats struct { //连接和拒绝的计数
+	ActiveConnections      int64
+	AcceptedConnections    uint64
+	RejectedMaxConnections uint64 //超过最大连接数被拒绝的连接
+	RejectedPerIP          uint64 //超过单个IP的连接数被拒绝的连接
+	RequestTimeouts        uint64 //读请求超时或者传输太慢回复408的请求
+	HeaderTooLarge         uint64 //header太多或太大回复431的请求
+}
+
+type connLimiter struct { //限制同时存在的连接数
+	maxConnections int
+	maxPerIP       int
+	lock           sync.Mutex
+	active         int
+	perIP          map[string]int
+	stats          ConnStats
+}
+
+func newConnLimiter() *connLimiter {
+	return &connLimiter{perIP: make(map[string]int)}
+}
+
+func connIP(conn net.Conn) string { //unix socket没有IP，返回空字符串
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func (request *Request) RemoteIP() string { //客户端的IP，unix socket的连接返回空字符串
+	return connIP(request.conn)
+}
+
+func (limiter *connLimiter) acquire(conn net.Conn) bool { //超过限制时返回false，调用方关掉连接
+	ip := connIP(conn)
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if limiter.maxConnections > 0 && limiter.active >= limiter.maxConnections {
+		atomic.AddUint64(&limiter.stats.RejectedMaxConnections, 1)
+		return false
+	}
+	if ip != "" && limiter.maxPerIP > 0 && limiter.perIP[ip] >= limiter.maxPerIP {
+		atomic.AddUint64(&limiter.stats.RejectedPerIP, 1)
+		return false
+	}
+	limiter.active++
+	if ip != "" {
+		limiter.perIP[ip]++
+	}
+	atomic.AddUint64(&limiter.stats.AcceptedConnections, 1)
+	return true
+}
+
+func (limiter *connLimiter) release(conn net.Conn) {
+	ip := connIP(conn)
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.active--
+	if ip != "" {
+		if limiter.perIP[ip] <= 1 {
+			delete(limiter.perIP, ip)
+		} else {
+			limiter.perIP[ip]--
+		}
+	}
+}
+
+func (app *AppStruct) SetConnectionLimits(maxConnections int, maxConnectionsPerIP int) { //设置最大连接数和单个IP的最大连接数，小于等于0为不限制
+	app.connLimiter.maxConnections = maxConnections
+	app.connLimiter.maxPerIP = maxConnectionsPerIP
+}
+
+func (app *AppStruct) SetMinTransferRate(bytesPerSecond int64) { //设置读header和body的最低速率（字节每秒），小于等于0为不限制
+	app.minTransferRate = bytesPerSecond
+}
+
+func (app *AppStruct) SetHeaderLimits(maxHeaderCount int, maxHeaderBytes int) { //设置header的最大数量和总大小，为0的保持不变，小于0为不限制
+	if maxHeaderCount != 0 {
+		app.maxHeaderCount = maxHeaderCount
+	}
+	if maxHeaderBytes != 0 {
+		app.maxHeaderBytes = maxHeaderBytes
+	}
+}
+
+func (app *AppStruct) ConnStats() ConnStats { //获取当前的连接计数
+	app.connLimiter.lock.Lock()
+	active := app.connLimiter.active
+	app.connLimiter.lock.Unlock()
+	return ConnStats{
+		int64(active),
+		atomic.LoadUint64(&app.connLimiter.stats.AcceptedConnections),
+		atomic.LoadUint64(&app.connLimiter.stats.RejectedMaxConnections),
+		atomic.LoadUint64(&app.connLimiter.stats.RejectedPerIP),
+		atomic.LoadUint64(&app.connLimiter.stats.RequestTimeouts),
+		atomic.LoadUint64(&app.connLimiter.stats.HeaderTooLarge),
+	}
+}
+
+func earlierDeadline(a time.Time, b time.Time) time.Time { //零值为不限制
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+func rateDeadline(start time.Time, transferred uint64, bytesPerSecond int64) time.Time { //按最低速率传完transferred个字节之后下一个字节最晚什么时候到
+	if bytesPerSecond <= 0 {
+		return time.Time{}
+	}
+	return start.Add(minTransferRateGrace + time.Duration(transferred+1)*time.Second/time.Duration(bytesPerSecond))
+}
+
+func (app *AppStruct) headerDeadline(start time.Time, headerBytes int) time.Time { //读header的期限，readHeaderTimeout和最低速率取先到的
+	deadline := time.Time{}
+	if app.readHeaderTimeout > 0 {
+		deadline = start.Add(app.readHeaderTimeout)
+	}
+	return earlierDeadline(deadline, rateDeadline(start, uint64(headerBytes), app.minTransferRate))
+}
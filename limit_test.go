@@ -0,0 +1,131 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T, app *AppStruct) string { //在随机端口上提供服务，测试结束时关掉
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.enableConsoleLog = false
+	go app.Serve(listener)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return listener.Addr().String()
+}
+
+func sendTestRequest(t *testing.T, conn net.Conn, raw string) string { //发送原始请求，返回状态行
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(status)
+}
+
+func TestHeaderLimits(t *testing.T) {
+	app := App()
+	app.Register(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, "/", false)
+	app.SetHeaderLimits(4, 100)
+	address := startTestServer(t, app)
+	tests := []struct {
+		name    string
+		headers string
+		status  string
+	}{
+		{"within limits", "Host: a\r\nX-A: 1\r\nX-B: 2\r\n", "HTTP/1.1 200 OK"},
+		{"too many", "Host: a\r\nX-A: 1\r\nX-B: 2\r\nX-C: 3\r\n", "HTTP/1.1 431 Request Header Fields Too Large"},
+		{"too large", "Host: a\r\nX-A: " + strings.Repeat("a", 100) + "\r\n", "HTTP/1.1 431 Request Header Fields Too Large"},
+	}
+	for _, test := range tests {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := sendTestRequest(t, conn, "GET / HTTP/1.1\r\n"+test.headers+"Connection: close\r\n\r\n"); status != test.status {
+			t.Errorf("%s: got %q", test.name, status)
+		}
+		conn.Close()
+	}
+	if stats := app.ConnStats(); stats.HeaderTooLarge != 2 {
+		t.Errorf("got HeaderTooLarge %d, want 2", stats.HeaderTooLarge)
+	}
+}
+
+func waitTestActiveConnections(t *testing.T, app *AppStruct, active int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for app.ConnStats().ActiveConnections != active {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d active connections, want %d", app.ConnStats().ActiveConnections, active)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	app := App()
+	app.Register(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, "/", false)
+	app.SetConnectionLimits(0, 1)
+	address := startTestServer(t, app)
+
+	first, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	waitTestActiveConnections(t, app, 1) //第一个连接不发请求，一直占着名额
+	second, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("a second connection from the same IP should be closed, got %v", err)
+	}
+	if stats := app.ConnStats(); stats.ActiveConnections != 1 || stats.RejectedPerIP != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+
+	first.Close()
+	waitTestActiveConnections(t, app, 0)
+	third, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if status := sendTestRequest(t, third, "GET / HTTP/1.1\r\nHost: a\r\n\r\n"); status != "HTTP/1.1 200 OK" {
+		t.Fatalf("after the first connection closed: got %q", status)
+	}
+}
+
+func TestRateDeadline(t *testing.T) {
+	start := time.Now()
+	if !rateDeadline(start, 100, 0).IsZero() {
+		t.Error("no minimum rate should mean no deadline")
+	}
+	if deadline := rateDeadline(start, 99, 100); !deadline.Equal(start.Add(minTransferRateGrace + time.Second)) {
+		t.Errorf("got %v", deadline.Sub(start))
+	}
+	app := App()
+	app.readHeaderTimeout = 2 * time.Second
+	app.SetMinTransferRate(1)
+	if deadline := app.headerDeadline(start, 0); !deadline.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("the earlier deadline should win, got %v", deadline.Sub(start))
+	}
+}
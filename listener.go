@@ -0,0 +1,230 @@
+package simpwebserv
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ListenConfig struct { //单个监听地址的配置
+	Network        string //tcp、tcp4、tcp6或unix，默认为tcp
+	Address        string //如0.0.0.0:80、[::]:443、/run/app.sock
+	UseTls         bool
+	UnixSocketPerm os.FileMode //unix socket文件的权限，0为不修改
+}
+
+func (listenConfig ListenConfig) url() string { //给日志用的地址
+	if listenConfig.Network == "unix" {
+		return "unix:" + listenConfig.Address
+	}
+	host, port, _ := net.SplitHostPort(listenConfig.Address)
+	if listenConfig.UseTls {
+		if port == "443" {
+			return "https://" + host
+		}
+		return "https://" + listenConfig.Address
+	}
+	if port == "80" {
+		return "http://" + host
+	}
+	return "http://" + listenConfig.Address
+}
+
+func listenUnixSocket(path string, perm os.FileMode) (net.Listener, error) { //监听unix socket，会先删掉残留的socket文件
+	if stat, err := os.Stat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil { //还有进程在用就不删
+			conn.Close()
+			return nil, ErrAddressInUse
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err = os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+func listen(listenConfig ListenConfig) (net.Listener, error) { //按配置创建监听（不含TLS）
+	if listenConfig.Network == "" {
+		listenConfig.Network = "tcp"
+	}
+	if listenConfig.Network == "unix" {
+		return listenUnixSocket(listenConfig.Address, listenConfig.UnixSocketPerm)
+	}
+	return net.Listen(listenConfig.Network, listenConfig.Address)
+}
+
+func (app *AppStruct) addListener(listener net.Listener, useTls bool) (net.Listener, error) { //记录下来给平滑升级用，需要时套上TLS
+	name := "http"
+	if useTls {
+		if app.HTTPSConfig == nil {
+			listener.Close()
+			return nil, ErrNoCertificateFound
+		}
+		name = "https"
+	}
+	app.upgradeListeners = append(app.upgradeListeners, namedListener{listener, name})
+	if useTls {
+		return tls.NewListener(listener, app.HTTPSConfig), nil
+	}
+	return listener, nil
+}
+
+func (app *AppStruct) openListeners(config Config) (listeners []net.Listener, redirectListener net.Listener, adminListener net.Listener, err error) { //创建Run用的所有监听，有继承的监听时只用继承的，第二、三个返回值为重定向端口和管理端口的监听
+	inheritedList, readyFile, err := inheritListeners()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer func() {
+		if err != nil && readyFile != nil { //不通知就关掉，旧进程读到EOF后认为升级失败，继续提供服务
+			readyFile.Close()
+		}
+	}()
+
+	if len(inheritedList) != 0 {
+		for i := 0; i < len(inheritedList); i++ {
+			if inheritedList[i].name == redirectListenName {
+				redirectListener = inheritedList[i].listener
+				app.upgradeListeners = append(app.upgradeListeners, inheritedList[i])
+				continue
+			}
+			if inheritedList[i].name == adminListenName {
+				adminListener = inheritedList[i].listener
+				app.upgradeListeners = append(app.upgradeListeners, inheritedList[i])
+				continue
+			}
+			useTls := inheritedList[i].useTls(app.useTls)
+			listener, err := app.addListener(inheritedList[i].listener, useTls)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			listeners = append(listeners, listener)
+			if app.enableConsoleLog {
+				log.Println("Server is starting at inherited socket: " + listenerAddress(inheritedList[i].listener) + " tls=" + strconv.FormatBool(useTls))
+			}
+		}
+	} else {
+		listenConfigs := app.listenConfigs(config)
+		for i := 0; i < len(listenConfigs); i++ {
+			listener, err := listen(listenConfigs[i])
+			if err == nil {
+				listener, err = app.addListener(listener, listenConfigs[i].UseTls)
+			}
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			listeners = append(listeners, listener)
+			if app.enableConsoleLog {
+				log.Println("Server is starting at: " + listenConfigs[i].url())
+			}
+		}
+	}
+	if len(listeners) == 0 {
+		return nil, nil, nil, ErrNoListener
+	}
+
+	if redirectListener == nil && app.useTls && config.RedirectHttpPort != 0 {
+		redirectListener, err = net.Listen("tcp", net.JoinHostPort(config.Host, strconv.Itoa(int(config.RedirectHttpPort))))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		app.upgradeListeners = append(app.upgradeListeners, namedListener{redirectListener, redirectListenName})
+	}
+	if adminListener == nil && config.AdminListener.Address != "" {
+		adminListener, err = listen(config.AdminListener)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		app.upgradeListeners = append(app.upgradeListeners, namedListener{adminListener, adminListenName})
+		if app.enableConsoleLog {
+			log.Println("Admin endpoints are at: " + config.AdminListener.url())
+		}
+	}
+	notifyUpgradeReady(readyFile) //所有监听都打开了才通知旧进程退出
+	return listeners, redirectListener, adminListener, nil
+}
+
+func (app *AppStruct) listenConfigs(config Config) []ListenConfig { //Host和Port加上Listeners里的所有监听地址
+	var listenConfigs []ListenConfig
+	if config.Port != 0 || len(config.Listeners) == 0 {
+		listenConfigs = append(listenConfigs, ListenConfig{"tcp", net.JoinHostPort(config.Host, strconv.Itoa(int(config.Port))), app.useTls, 0})
+	}
+	return append(listenConfigs, config.Listeners...)
+}
+
+func (app *AppStruct) Serve(listener net.Listener) error { //在一个已有的监听上提供服务，所有监听共用同一套路由，会一直阻塞到监听关闭
+	app.listenerLock.Lock()
+	app.listeners = append(app.listeners, listener)
+	app.listenerLock.Unlock()
+	for i := 0; i < int(app.multiThreadAcceptNum)-1; i++ {
+		go app.acceptConn(listener)
+	}
+	err := app.acceptConn(listener)
+	listener.Close()
+	return err
+}
+
+func (app *AppStruct) ServeTls(listener net.Listener) error { //在一个已有的监听上提供HTTPS服务，需要先设置好证书
+	if app.HTTPSConfig == nil {
+		return ErrNoCertificateFound
+	}
+	return app.Serve(tls.NewListener(listener, app.HTTPSConfig))
+}
+
+func (app *AppStruct) acceptConn(listener net.Listener) error {
+	var tempDelay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() { //比如文件描述符用完了，等一会再试
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else if tempDelay *= 2; tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				if app.enableConsoleLog {
+					log.Println("Server accept error: " + err.Error())
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		if !app.connLimiter.acquire(conn) {
+			conn.Close()
+			continue
+		}
+		go connectionHandler(conn, app)
+	}
+}
+
+func (app *AppStruct) serveAll(listeners []net.Listener) { //同时在多个监听上提供服务，全部关闭后返回
+	var waitGroup sync.WaitGroup
+	for i := 0; i < len(listeners); i++ {
+		waitGroup.Add(1)
+		go func(listener net.Listener) {
+			defer waitGroup.Done()
+			err := app.Serve(listener)
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Println("Server accept error: " + err.Error())
+			}
+		}(listeners[i])
+	}
+	waitGroup.Wait()
+}
@@ -0,0 +1,206 @@
+package simpwebserv
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	defaultSizeBuckets    = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+	metricsMethods        = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true, "CONNECT": true, "TRACE": true}
+)
+
+const unmatchedRoute = "unmatched" //没有匹配到注册路径的请求（404、ACME验证等）
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64 //每个桶自己的计数，输出时再累加
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i := 0; i < len(h.buckets); i++ {
+		if value <= h.buckets[i] {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+type routeMethod struct {
+	route  string
+	method string
+}
+
+type requestKey struct {
+	routeMethod
+	code string
+}
+
+type serverMetrics struct { //请求相关的监控指标，按路由、方法和状态码分类
+	lock            sync.Mutex
+	requests        map[requestKey]uint64
+	latency         map[routeMethod]*histogram
+	responseSize    map[routeMethod]*histogram
+	inFlight        int64
+	keepAliveReused uint64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{requests: make(map[requestKey]uint64), latency: make(map[routeMethod]*histogram), responseSize: make(map[routeMethod]*histogram)}
+}
+
+func (metrics *serverMetrics) startRequest(reused bool) {
+	atomic.AddInt64(&metrics.inFlight, 1)
+	if reused {
+		atomic.AddUint64(&metrics.keepAliveReused, 1)
+	}
+}
+
+func (metrics *serverMetrics) finishRequest(request *Request, response *Response, start time.Time) {
+	atomic.AddInt64(&metrics.inFlight, -1)
+	key := routeMethod{request.route, request.Method}
+	if key.route == "" {
+		key.route = unmatchedRoute
+	}
+	if !metricsMethods[key.method] { //防止乱写的方法名撑爆标签
+		key.method = "OTHER"
+	}
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	metrics.requests[requestKey{key, response.Code}]++
+	if metrics.latency[key] == nil {
+		metrics.latency[key] = newHistogram(defaultLatencyBuckets)
+		metrics.responseSize[key] = newHistogram(defaultSizeBuckets)
+	}
+	metrics.latency[key].observe(time.Since(start).Seconds())
+	metrics.responseSize[key].observe(float64(request.bytesSent))
+}
+
+func (request *Request) Route() string { //匹配到的注册路径，如/api/users或/static/*，没有匹配到时为空字符串
+	return request.route
+}
+
+func (app *AppStruct) EnableMetrics() { //开始记录监控指标，RegisterMetrics会自动调用
+	if app.metrics == nil {
+		app.metrics = newServerMetrics()
+	}
+}
+
+func (app *AppStruct) RegisterMetrics(path string) { //在path上提供Prometheus文本格式的监控指标
+	app.EnableMetrics()
+	app.Register(app.MetricsHandler(), path, false)
+}
+
+func (app *AppStruct) MetricsHandler() func(*Request) *Response { //返回输出监控指标的处理函数，可以注册到别的实例（比如管理端口）上
+	app.EnableMetrics()
+	return func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		app.writeMetrics(response.Body)
+		return response
+	}
+}
+
+var metricsLabelReplacer = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func metricsLabels(pairs ...string) string { //按name、value成对传入
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i != 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(pairs[i] + "=\"" + metricsLabelReplacer.Replace(pairs[i+1]) + "\"")
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+func formatMetricFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeMetricHeader(writer *strings.Builder, name string, metricType string, help string) {
+	writer.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + metricType + "\n")
+}
+
+func writeHistograms(writer *strings.Builder, name string, help string, histograms map[routeMethod]*histogram) {
+	writeMetricHeader(writer, name, "histogram", help)
+	keys := make([]routeMethod, 0, len(histograms))
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].route < keys[j].route || (keys[i].route == keys[j].route && keys[i].method < keys[j].method)
+	})
+	for _, key := range keys {
+		h := histograms[key]
+		var cumulative uint64
+		for i := 0; i < len(h.buckets); i++ {
+			cumulative += h.counts[i]
+			writer.WriteString(name + "_bucket" + metricsLabels("route", key.route, "method", key.method, "le", formatMetricFloat(h.buckets[i])) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		labels := metricsLabels("route", key.route, "method", key.method)
+		writer.WriteString(name + "_bucket" + metricsLabels("route", key.route, "method", key.method, "le", "+Inf") + " " + strconv.FormatUint(h.count, 10) + "\n")
+		writer.WriteString(name + "_sum" + labels + " " + formatMetricFloat(h.sum) + "\n")
+		writer.WriteString(name + "_count" + labels + " " + strconv.FormatUint(h.count, 10) + "\n")
+	}
+}
+
+func (app *AppStruct) writeMetrics(body *bytes.Buffer) {
+	var writer strings.Builder
+	metrics := app.metrics
+	stats := app.ConnStats()
+
+	metrics.lock.Lock()
+	writeMetricHeader(&writer, "http_requests_total", "counter", "Total number of HTTP requests by route, method and status code.")
+	keys := make([]requestKey, 0, len(metrics.requests))
+	for key := range metrics.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, key := range keys {
+		writer.WriteString("http_requests_total" + metricsLabels("route", key.route, "method", key.method, "code", key.code) + " " + strconv.FormatUint(metrics.requests[key], 10) + "\n")
+	}
+	writeHistograms(&writer, "http_request_duration_seconds", "HTTP request latency in seconds.", metrics.latency)
+	writeHistograms(&writer, "http_response_size_bytes", "HTTP response body size in bytes.", metrics.responseSize)
+	metrics.lock.Unlock()
+
+	writeMetricHeader(&writer, "http_requests_in_flight", "gauge", "Number of HTTP requests currently being served.")
+	writer.WriteString("http_requests_in_flight " + strconv.FormatInt(atomic.LoadInt64(&metrics.inFlight), 10) + "\n")
+	writeMetricHeader(&writer, "http_keepalive_reused_requests_total", "counter", "Number of requests served on a reused keep-alive connection.")
+	writer.WriteString("http_keepalive_reused_requests_total " + strconv.FormatUint(atomic.LoadUint64(&metrics.keepAliveReused), 10) + "\n")
+	writeMetricHeader(&writer, "http_open_connections", "gauge", "Number of open client connections.")
+	writer.WriteString("http_open_connections " + strconv.FormatInt(stats.ActiveConnections, 10) + "\n")
+	writeMetricHeader(&writer, "http_connections_accepted_total", "counter", "Number of accepted client connections.")
+	writer.WriteString("http_connections_accepted_total " + strconv.FormatUint(stats.AcceptedConnections, 10) + "\n")
+	writeMetricHeader(&writer, "http_connections_rejected_total", "counter", "Number of rejected client connections by reason.")
+	writer.WriteString("http_connections_rejected_total" + metricsLabels("reason", "max_connections") + " " + strconv.FormatUint(stats.RejectedMaxConnections, 10) + "\n")
+	writer.WriteString("http_connections_rejected_total" + metricsLabels("reason", "per_ip") + " " + strconv.FormatUint(stats.RejectedPerIP, 10) + "\n")
+	writeMetricHeader(&writer, "http_request_errors_total", "counter", "Number of requests rejected while reading them, by reason.")
+	writer.WriteString("http_request_errors_total" + metricsLabels("reason", "timeout") + " " + strconv.FormatUint(stats.RequestTimeouts, 10) + "\n")
+	writer.WriteString("http_request_errors_total" + metricsLabels("reason", "header_too_large") + " " + strconv.FormatUint(stats.HeaderTooLarge, 10) + "\n")
+	body.WriteString(writer.String())
+}
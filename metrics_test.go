@@ -0,0 +1,52 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRouteLabel(t *testing.T) {
+	app := App()
+	app.SetEnableKeepAlive(true)
+	app.RegisterMetrics("/metrics")
+	app.Use(func(next func(*Request) *Response) func(*Request) *Response { //模拟认证中间件，不进处理函数直接回复401
+		return func(request *Request) *Response {
+			if request.Header.Get("Authorization") == "" {
+				return Build401Response()
+			}
+			return next(request)
+		}
+	})
+	app.Register(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, "/api/users", false)
+	app.Register(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, "/static", true)
+	client := servePipe(t, app)
+	reader := bufio.NewReader(client)
+	for _, raw := range []string{
+		"GET /api/users HTTP/1.1\r\nHost: a\r\n\r\n",
+		"GET /api/users HTTP/1.1\r\nHost: a\r\nAuthorization: x\r\n\r\n",
+		"GET /static/app.js HTTP/1.1\r\nHost: a\r\n\r\n",
+		"GET /missing HTTP/1.1\r\nHost: a\r\nAuthorization: x\r\n\r\n",
+		"BREW /api/users HTTP/1.1\r\nHost: a\r\n\r\n",
+	} {
+		go client.Write([]byte(raw))
+		readTestResponse(t, reader)
+	}
+	go client.Write([]byte("GET /metrics HTTP/1.1\r\nHost: a\r\nAuthorization: x\r\n\r\n")) //同一个连接上前面的请求都已经统计完了
+	_, metrics := readTestResponse(t, reader)
+	for _, want := range []string{
+		`http_requests_total{route="/api/users",method="GET",code="401"} 1`,
+		`http_requests_total{route="/api/users",method="GET",code="200"} 1`,
+		`http_requests_total{route="/static/*",method="GET",code="401"} 1`,
+		`http_requests_total{route="unmatched",method="GET",code="404"} 1`,
+		`http_requests_total{route="/api/users",method="OTHER",code="401"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Fatalf("missing %s in\n%s", want, metrics)
+		}
+	}
+}
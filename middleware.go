@@ -0,0 +1,24 @@
+package simpwebserv
+
+type Middleware func(func(*Request) *Response) func(*Request) *Response //中间件，包一层处理函数
+
+func Chain(function func(*Request) *Response, middlewares ...Middleware) func(*Request) *Response { //把中间件套到单个处理函数上（按顺序从外到内），用于给单个路径加中间件
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		function = middlewares[i](function)
+	}
+	return function
+}
+
+func (app *AppStruct) Use(middlewares ...Middleware) { //添加全局中间件，在路由之前执行，按添加顺序从外到内
+	app.middlewareList = append(app.middlewareList, middlewares...)
+	app.handler = Chain(app.dispatch, app.middlewareList...)
+}
+
+func (app *AppStruct) dispatch(request *Request) *Response { //按路径找到处理函数并执行
+	found, function, pattern := getFunction(app, request.Path)
+	if !found || function == nil {
+		return Build404Response()
+	}
+	request.route = pattern
+	return function(request)
+}
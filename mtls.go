@@ -0,0 +1,174 @@
+package simpwebserv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+)
+
+const (
+	TlsClientAuthNone             = iota //不要客户端证书
+	TlsClientAuthRequest                 //请求客户端证书，但不验证也不强制
+	TlsClientAuthRequire                 //必须有客户端证书，但不验证
+	TlsClientAuthVerifyIfGiven           //有客户端证书就验证
+	TlsClientAuthRequireAndVerify        //必须有客户端证书并且验证通过
+)
+
+type ClientCertificate struct { //客户端证书里的身份信息
+	Certificate        *x509.Certificate
+	Chain              []*x509.Certificate //验证通过的证书链，没验证时为nil
+	Verified           bool
+	CommonName         string
+	Organization       []string
+	OrganizationalUnit []string
+	DNSNames           []string
+	EmailAddresses     []string
+	IPAddresses        []string
+	URIs               []string
+}
+
+func tlsClientAuthType(mode int) tls.ClientAuthType {
+	switch mode {
+	case TlsClientAuthRequest:
+		return tls.RequestClientCert
+	case TlsClientAuthRequire:
+		return tls.RequireAnyClientCert
+	case TlsClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven
+	case TlsClientAuthRequireAndVerify:
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.NoClientCert
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) { //读取PEM格式的CA证书包
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, ErrNoCertificateFound
+	}
+	return pool, nil
+}
+
+func (app *AppStruct) applyTlsClientAuth() { //把客户端证书设置应用到HTTPSConfig上
+	if app.HTTPSConfig == nil {
+		return
+	}
+	app.HTTPSConfig.ClientCAs = app.tlsClientCAs
+	app.HTTPSConfig.ClientAuth = app.tlsClientAuth
+}
+
+func (app *AppStruct) SetTlsClientAuth(caPath string, mode int) error { //设置客户端证书认证（mTLS），caPath为签发客户端证书的CA
+	if caPath != "" {
+		pool, err := loadCertPool(caPath)
+		if err != nil {
+			return err
+		}
+		app.tlsClientCAs = pool
+	}
+	app.tlsClientAuth = tlsClientAuthType(mode)
+	app.applyTlsClientAuth()
+	return nil
+}
+
+func (request *Request) tlsConn() (*tls.Conn, bool) { //中间件可能把连接包了一层，先拆开
+	conn := request.conn
+	for {
+		if wrapped, ok := conn.(interface{ unwrapConn() net.Conn }); ok {
+			conn = wrapped.unwrapConn()
+			continue
+		}
+		tlsConn, ok := conn.(*tls.Conn)
+		return tlsConn, ok
+	}
+}
+
+func (request *Request) TlsConnectionState() (tls.ConnectionState, bool) { //获取TLS连接状态，不是TLS连接时返回false
+	tlsConn, ok := request.tlsConn()
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tlsConn.ConnectionState(), true
+}
+
+func (request *Request) ClientCertificate() *ClientCertificate { //获取客户端证书信息，没有时返回nil
+	state, ok := request.TlsConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	info := &ClientCertificate{Certificate: cert, CommonName: cert.Subject.CommonName, Organization: cert.Subject.Organization, OrganizationalUnit: cert.Subject.OrganizationalUnit, DNSNames: cert.DNSNames, EmailAddresses: cert.EmailAddresses}
+	if len(state.VerifiedChains) != 0 {
+		info.Verified = true
+		info.Chain = state.VerifiedChains[0]
+	}
+	for i := 0; i < len(cert.IPAddresses); i++ {
+		info.IPAddresses = append(info.IPAddresses, cert.IPAddresses[i].String())
+	}
+	for i := 0; i < len(cert.URIs); i++ {
+		info.URIs = append(info.URIs, cert.URIs[i].String())
+	}
+	return info
+}
+
+type ClientCertRule struct { //客户端证书的匹配规则，每个非空的列表都要至少匹配上一项
+	AllowUnverified     bool //允许没经过CA验证的证书（TlsClientAuthRequest/TlsClientAuthRequire模式下）
+	CommonNames         []string
+	Organizations       []string
+	OrganizationalUnits []string
+	DNSNames            []string
+	EmailAddresses      []string
+	URIs                []string
+	Match               func(*ClientCertificate) bool //自定义检查
+}
+
+func matchAny(values []string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for i := 0; i < len(values); i++ {
+		for j := 0; j < len(allowed); j++ {
+			if values[i] == allowed[j] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rule *ClientCertRule) matches(info *ClientCertificate) bool {
+	if !info.Verified && !rule.AllowUnverified {
+		return false
+	}
+	if !matchAny([]string{info.CommonName}, rule.CommonNames) || !matchAny(info.Organization, rule.Organizations) || !matchAny(info.OrganizationalUnit, rule.OrganizationalUnits) {
+		return false
+	}
+	if !matchAny(info.DNSNames, rule.DNSNames) || !matchAny(info.EmailAddresses, rule.EmailAddresses) || !matchAny(info.URIs, rule.URIs) {
+		return false
+	}
+	return rule.Match == nil || rule.Match(info)
+}
+
+func ClientCertMiddleware(rules ...ClientCertRule) Middleware { //要求客户端证书满足任意一条规则，通过后request.User为证书的CommonName
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			info := request.ClientCertificate()
+			if info == nil {
+				return Build403Response()
+			}
+			for i := 0; i < len(rules); i++ {
+				if rules[i].matches(info) {
+					if request.User == "" {
+						request.User = info.CommonName
+					}
+					return next(request)
+				}
+			}
+			return Build403Response()
+		}
+	}
+}
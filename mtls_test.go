@@ -0,0 +1,173 @@
+package simpwebserv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) { //parent为nil时自签名
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+type testPKI struct {
+	caPool     *x509.CertPool
+	server     tls.Certificate
+	client     tls.Certificate
+	selfSigned tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	ca, caKey := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	server, serverKey := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}, DNSNames: []string{"localhost"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca, caKey)
+	spiffe, _ := url.Parse("spiffe://example.com/billing")
+	client, clientKey := newTestCertificate(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "billing", Organization: []string{"Example"}, OrganizationalUnit: []string{"payments"}},
+		DNSNames:       []string{"billing.internal"},
+		EmailAddresses: []string{"billing@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffe},
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	selfSigned, selfSignedKey := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, nil, nil)
+	pki := &testPKI{caPool: x509.NewCertPool()}
+	pki.caPool.AddCert(ca)
+	pki.server = tls.Certificate{Certificate: [][]byte{server.Raw}, PrivateKey: serverKey, Leaf: server}
+	pki.client = tls.Certificate{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey, Leaf: client}
+	pki.selfSigned = tls.Certificate{Certificate: [][]byte{selfSigned.Raw}, PrivateKey: selfSignedKey, Leaf: selfSigned}
+	return pki
+}
+
+func newTestTlsRequest(t *testing.T, pki *testPKI, clientAuth int, clientCerts []tls.Certificate) *Request { //在内存管道上完成握手，返回服务端连接上的请求
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	serverConn := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{pki.server}, ClientCAs: pki.caPool, ClientAuth: tlsClientAuthType(clientAuth)})
+	clientConn := tls.Client(client, &tls.Config{RootCAs: pki.caPool, ServerName: "localhost", GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if len(clientCerts) == 0 {
+			return &tls.Certificate{}, nil
+		}
+		return &clientCerts[0], nil //不是服务端CA签发的证书也发过去
+	}})
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- clientConn.Handshake()
+	}()
+	if err := serverConn.Handshake(); err != nil {
+		t.Fatalf("server handshake: %v", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	request := newTestRequest(t, "GET", "/")
+	request.conn = serverConn
+	return request
+}
+
+func TestClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	request := newTestTlsRequest(t, pki, TlsClientAuthRequireAndVerify, []tls.Certificate{pki.client})
+	info := request.ClientCertificate()
+	if info == nil || !info.Verified || len(info.Chain) != 2 {
+		t.Fatalf("got %+v", info)
+	}
+	if info.CommonName != "billing" || info.Organization[0] != "Example" || info.OrganizationalUnit[0] != "payments" || info.DNSNames[0] != "billing.internal" {
+		t.Errorf("got %+v", info)
+	}
+	if info.EmailAddresses[0] != "billing@example.com" || info.IPAddresses[0] != "10.0.0.1" || info.URIs[0] != "spiffe://example.com/billing" {
+		t.Errorf("got %+v", info)
+	}
+
+	request = newTestTlsRequest(t, pki, TlsClientAuthRequire, []tls.Certificate{pki.selfSigned})
+	if info := request.ClientCertificate(); info == nil || info.Verified || info.Chain != nil {
+		t.Errorf("unverified certificate: got %+v", info)
+	}
+	if request := newTestTlsRequest(t, pki, TlsClientAuthVerifyIfGiven, nil); request.ClientCertificate() != nil || !request.IsTls() {
+		t.Error("no certificate given")
+	}
+	if newTestRequest(t, "GET", "/").ClientCertificate() != nil {
+		t.Error("plain connection should have no certificate")
+	}
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	pki := newTestPKI(t)
+	handler := func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.Body.WriteString(request.User)
+		return response
+	}
+	tests := []struct {
+		name  string
+		rules []ClientCertRule
+		cert  tls.Certificate
+		mode  int
+		ok    bool
+	}{
+		{"any verified", []ClientCertRule{{}}, pki.client, TlsClientAuthRequireAndVerify, true},
+		{"common name", []ClientCertRule{{CommonNames: []string{"billing"}}}, pki.client, TlsClientAuthRequireAndVerify, true},
+		{"wrong common name", []ClientCertRule{{CommonNames: []string{"admin"}}}, pki.client, TlsClientAuthRequireAndVerify, false},
+		{"all lists must match", []ClientCertRule{{Organizations: []string{"Example"}, OrganizationalUnits: []string{"hr"}}}, pki.client, TlsClientAuthRequireAndVerify, false},
+		{"any rule", []ClientCertRule{{OrganizationalUnits: []string{"hr"}}, {URIs: []string{"spiffe://example.com/billing"}}}, pki.client, TlsClientAuthRequireAndVerify, true},
+		{"san lists", []ClientCertRule{{DNSNames: []string{"billing.internal"}, EmailAddresses: []string{"billing@example.com"}}}, pki.client, TlsClientAuthRequireAndVerify, true},
+		{"custom match", []ClientCertRule{{Match: func(info *ClientCertificate) bool { return len(info.IPAddresses) == 0 }}}, pki.client, TlsClientAuthRequireAndVerify, false},
+		{"unverified", []ClientCertRule{{CommonNames: []string{"billing"}}}, pki.selfSigned, TlsClientAuthRequire, false},
+		{"unverified allowed", []ClientCertRule{{CommonNames: []string{"billing"}, AllowUnverified: true}}, pki.selfSigned, TlsClientAuthRequire, true},
+	}
+	for _, test := range tests {
+		request := newTestTlsRequest(t, pki, test.mode, []tls.Certificate{test.cert})
+		response := Chain(handler, ClientCertMiddleware(test.rules...))(request)
+		if (response.Code == "200") != test.ok {
+			t.Errorf("%s: got %s", test.name, response.Code)
+		}
+		if test.ok && response.Body.String() != "billing" {
+			t.Errorf("%s: request.User is %q", test.name, response.Body.String())
+		}
+	}
+	if response := Chain(handler, ClientCertMiddleware(ClientCertRule{}))(newTestRequest(t, "GET", "/")); response.Code != "403" {
+		t.Errorf("plain connection: got %s", response.Code)
+	}
+}
+
+func TestSetTlsClientAuth(t *testing.T) {
+	app := App()
+	app.HTTPSConfig = &tls.Config{}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(path, []byte("not a certificate"), 0600)
+	if err := app.SetTlsClientAuth(path, TlsClientAuthRequireAndVerify); err != ErrNoCertificateFound {
+		t.Fatalf("got %v, want ErrNoCertificateFound", err)
+	}
+	if err := app.SetTlsClientAuth("", TlsClientAuthVerifyIfGiven); err != nil || app.HTTPSConfig.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Fatalf("got %v, %v", err, app.HTTPSConfig.ClientAuth)
+	}
+}
@@ -0,0 +1,259 @@
+package simpwebserv
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitTokenBucket   = iota //令牌桶，允许短时间突发到Burst个请求
+	RateLimitSlidingWindow        //滑动窗口，按上一个窗口的计数加权估算
+)
+
+const defaultRateLimitMaxKeys = 100000
+
+type RateLimitRule struct { //限流规则，Window内最多Limit个请求
+	Algorithm int
+	Limit     int
+	Window    time.Duration
+	Burst     int //令牌桶的容量，默认等于Limit
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration //多久之后额度完全恢复（滑动窗口为当前窗口结束）
+	RetryAfter time.Duration //被拒绝时多久之后可以重试
+}
+
+type RateLimitStore interface { //限流状态的存储，可以换成共享的存储让多个实例一起限流
+	Take(key string, rule RateLimitRule, now time.Time) (RateLimitResult, error) //消耗一次额度
+}
+
+type rateLimitEntry struct {
+	tokens      float64   //令牌桶剩余的令牌
+	last        time.Time //令牌桶上次更新的时间
+	windowStart time.Time //滑动窗口当前窗口的开始时间
+	current     int
+	previous    int
+	expire      time.Time //之后状态和新建的一样，可以删掉
+}
+
+type MemoryRateLimitStore struct { //内存限流存储
+	lock    sync.Mutex
+	entries map[string]*rateLimitEntry
+	maxKeys int
+	stop    chan struct{}
+}
+
+func NewMemoryRateLimitStore(gcInterval time.Duration, maxKeys int) *MemoryRateLimitStore { //创建内存限流存储，每gcInterval清理一次过期的key，超过maxKeys（0为默认10万）时会丢掉一部分key
+	if maxKeys <= 0 {
+		maxKeys = defaultRateLimitMaxKeys
+	}
+	store := &MemoryRateLimitStore{entries: make(map[string]*rateLimitEntry), maxKeys: maxKeys, stop: make(chan struct{})}
+	if gcInterval > 0 {
+		go store.gcLoop(gcInterval)
+	}
+	return store
+}
+
+func (store *MemoryRateLimitStore) gcLoop(gcInterval time.Duration) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			store.GC()
+		case <-store.stop:
+			return
+		}
+	}
+}
+
+func (store *MemoryRateLimitStore) GC() { //清理过期的key
+	now := time.Now()
+	store.lock.Lock()
+	store.gc(now)
+	store.lock.Unlock()
+}
+
+func (store *MemoryRateLimitStore) gc(now time.Time) {
+	for key, entry := range store.entries {
+		if now.After(entry.expire) {
+			delete(store.entries, key)
+		}
+	}
+}
+
+func (store *MemoryRateLimitStore) Close() { //停止后台清理
+	close(store.stop)
+}
+
+func (store *MemoryRateLimitStore) Take(key string, rule RateLimitRule, now time.Time) (RateLimitResult, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	entry, ok := store.entries[key]
+	if !ok {
+		if len(store.entries) >= store.maxKeys {
+			store.gc(now)
+			for evictKey := range store.entries { //还是满的话随便丢掉一些，被丢掉的key额度会重置
+				if len(store.entries) < store.maxKeys {
+					break
+				}
+				delete(store.entries, evictKey)
+			}
+		}
+		entry = &rateLimitEntry{tokens: float64(rule.burst()), last: now}
+		store.entries[key] = entry
+	}
+	if rule.Algorithm == RateLimitSlidingWindow {
+		return entry.takeSlidingWindow(rule, now), nil
+	}
+	return entry.takeTokenBucket(rule, now), nil
+}
+
+func (rule RateLimitRule) burst() int {
+	if rule.Burst > 0 {
+		return rule.Burst
+	}
+	return rule.Limit
+}
+
+func (entry *rateLimitEntry) takeTokenBucket(rule RateLimitRule, now time.Time) RateLimitResult {
+	burst := float64(rule.burst())
+	perToken := rule.Window / time.Duration(rule.Limit) //补充一个令牌要多久
+	if elapsed := now.Sub(entry.last); elapsed > 0 {
+		entry.tokens = math.Min(burst, entry.tokens+float64(elapsed)/float64(perToken))
+	}
+	entry.last = now
+	result := RateLimitResult{Limit: rule.burst()}
+	if entry.tokens >= 1 {
+		entry.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - entry.tokens) * float64(perToken))
+	}
+	result.Remaining = int(entry.tokens)
+	result.Reset = time.Duration((burst - entry.tokens) * float64(perToken))
+	entry.expire = now.Add(result.Reset)
+	return result
+}
+
+func (entry *rateLimitEntry) takeSlidingWindow(rule RateLimitRule, now time.Time) RateLimitResult {
+	windowStart := now.Truncate(rule.Window)
+	if !windowStart.Equal(entry.windowStart) {
+		if windowStart.Sub(entry.windowStart) == rule.Window {
+			entry.previous = entry.current
+		} else {
+			entry.previous = 0
+		}
+		entry.current = 0
+		entry.windowStart = windowStart
+	}
+	elapsed := now.Sub(windowStart)
+	weight := 1 - float64(elapsed)/float64(rule.Window)
+	estimated := float64(entry.previous)*weight + float64(entry.current)
+	result := RateLimitResult{Limit: rule.Limit, Reset: rule.Window - elapsed}
+	if estimated+1 <= float64(rule.Limit) {
+		entry.current++
+		estimated++
+		result.Allowed = true
+	} else if entry.current+1 <= rule.Limit { //当前窗口还有空间，等上一个窗口的权重降下来
+		needWeight := float64(rule.Limit-entry.current-1) / float64(entry.previous)
+		result.RetryAfter = time.Duration((1-needWeight)*float64(rule.Window)) - elapsed
+	} else { //要等到下一个窗口，那时上一个窗口的计数就是现在的current
+		needWeight := float64(rule.Limit-1) / float64(entry.current)
+		result.RetryAfter = result.Reset + time.Duration((1-needWeight)*float64(rule.Window))
+	}
+	result.Remaining = rule.Limit - int(math.Ceil(estimated))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	if result.RetryAfter < 0 {
+		result.RetryAfter = 0
+	}
+	entry.expire = windowStart.Add(2 * rule.Window)
+	return result
+}
+
+type RateLimitConfig struct { //限流中间件的配置
+	RateLimitRule
+	KeyFunc  func(*Request) string                     //按什么限流，默认按客户端IP，返回空字符串时不限流
+	Store    RateLimitStore                            //默认为内存存储
+	Response func(*Request, RateLimitResult) *Response //自定义429响应，为nil时使用默认页面
+}
+
+func RateLimitKeyByIP(request *Request) string { //按客户端IP限流，unix socket的连接都算作同一个
+	if ip := request.RemoteIP(); ip != "" {
+		return "ip:" + ip
+	}
+	return "unix"
+}
+
+func RateLimitKeyByHeader(name string) func(*Request) string { //按header的值限流，比如API key，没有这个header时按IP；客户端换个值就能绕过，只能在可信的代理或认证已经校验过这个header时使用
+	return func(request *Request) string {
+		if value := request.Header.Get(name); value != "" {
+			return "header:" + value
+		}
+		return RateLimitKeyByIP(request)
+	}
+}
+
+func ceilSeconds(duration time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(duration.Seconds())), 10)
+}
+
+func RateLimitMiddleware(config RateLimitConfig) Middleware { //限流中间件，超过限制时回复429，存储出错时放行
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	if config.Limit <= 0 {
+		config.Limit = 60
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = RateLimitKeyByIP
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryRateLimitStore(time.Minute, 0)
+	}
+	policy := strconv.Itoa(config.Limit) + ";w=" + ceilSeconds(config.Window)
+	if config.Algorithm == RateLimitTokenBucket {
+		policy += ";burst=" + strconv.Itoa(config.burst())
+	}
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			key := config.KeyFunc(request)
+			if key == "" {
+				return next(request)
+			}
+			result, err := config.Store.Take(key, config.RateLimitRule, time.Now())
+			if err != nil {
+				log.Println("Rate limit store error: " + err.Error())
+				return next(request)
+			}
+			var response *Response
+			if result.Allowed {
+				response = next(request)
+			} else if config.Response != nil {
+				response = config.Response(request, result)
+			} else {
+				response = Build429Response()
+			}
+			if response.sendedHeader { //处理函数已经自己发了header，再加也发不出去
+				return response
+			}
+			if !result.Allowed {
+				response.Header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+			}
+			response.Header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			response.Header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			response.Header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+			response.Header.Set("RateLimit-Policy", policy)
+			return response
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package simpwebserv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var rateLimitTestStart = time.Unix(1699999980, 0) //正好是整分钟
+
+func takeN(t *testing.T, store RateLimitStore, rule RateLimitRule, now time.Time, n int) (allowed int, last RateLimitResult) {
+	for i := 0; i < n; i++ {
+		result, err := store.Take("key", rule, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed {
+			allowed++
+		}
+		last = result
+	}
+	return allowed, last
+}
+
+func TestTokenBucket(t *testing.T) {
+	store := NewMemoryRateLimitStore(0, 0)
+	rule := RateLimitRule{Algorithm: RateLimitTokenBucket, Limit: 10, Window: 10 * time.Second, Burst: 3}
+	now := rateLimitTestStart
+	if allowed, last := takeN(t, store, rule, now, 3); allowed != 3 || last.Remaining != 0 || last.Limit != 3 || last.Reset != 3*time.Second {
+		t.Fatalf("burst: allowed %d, got %+v", allowed, last)
+	}
+	if _, last := takeN(t, store, rule, now, 1); last.Allowed || last.RetryAfter != time.Second {
+		t.Fatalf("empty bucket: got %+v", last)
+	}
+	if allowed, _ := takeN(t, store, rule, now.Add(1500*time.Millisecond), 2); allowed != 1 {
+		t.Fatalf("one token refilled, %d allowed", allowed)
+	}
+	if allowed, _ := takeN(t, store, rule, now.Add(time.Hour), 5); allowed != 3 {
+		t.Fatalf("refill is capped at the burst, %d allowed", allowed)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore(0, 0)
+	rule := RateLimitRule{Algorithm: RateLimitSlidingWindow, Limit: 10, Window: time.Minute}
+	now := rateLimitTestStart
+	allowed, last := takeN(t, store, rule, now, 11)
+	if allowed != 10 || last.Allowed || last.Remaining != 0 || last.Reset != time.Minute {
+		t.Fatalf("first window: allowed %d, got %+v", allowed, last)
+	}
+	if last.RetryAfter < 66*time.Second-time.Millisecond || last.RetryAfter > 66*time.Second { //下一个窗口里上一个窗口的权重要降到0.9，有浮点误差
+		t.Fatalf("got RetryAfter %v", last.RetryAfter)
+	}
+	if allowed, last := takeN(t, store, rule, now.Add(90*time.Second), 6); allowed != 5 || last.RetryAfter != 6*time.Second { //上一个窗口的权重要从0.5降到0.4
+		t.Fatalf("half way into the next window: allowed %d, got %+v", allowed, last)
+	}
+	if allowed, _ := takeN(t, store, rule, now.Add(5*time.Minute), 11); allowed != 10 {
+		t.Fatalf("after an idle window the count should reset, %d allowed", allowed)
+	}
+}
+
+func TestMemoryRateLimitStoreEviction(t *testing.T) {
+	store := NewMemoryRateLimitStore(0, 2)
+	rule := RateLimitRule{Algorithm: RateLimitTokenBucket, Limit: 1, Window: time.Minute}
+	for _, key := range []string{"a", "b", "c"} {
+		store.Take(key, rule, rateLimitTestStart)
+	}
+	if len(store.entries) > 2 {
+		t.Fatalf("got %d keys, want at most 2", len(store.entries))
+	}
+	store.gc(rateLimitTestStart.Add(2 * time.Minute))
+	if len(store.entries) != 0 {
+		t.Fatalf("expired keys should be collected, %d left", len(store.entries))
+	}
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(key string, rule RateLimitRule, now time.Time) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store down")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	handler := Chain(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, RateLimitMiddleware(RateLimitConfig{RateLimitRule: RateLimitRule{Limit: 2, Window: time.Minute}, KeyFunc: RateLimitKeyByHeader("X-API-Key")}))
+	request := func(key string) *Response {
+		request := newTestRequest(t, "GET", "/")
+		request.Header.Set("X-API-Key", key)
+		return handler(request)
+	}
+	request("a")
+	response := request("a")
+	if response.Code != "200" || response.Header.Get("RateLimit-Remaining") != "0" || response.Header.Get("RateLimit-Policy") != "2;w=60;burst=2" {
+		t.Fatalf("got %s %v", response.Code, response.Header)
+	}
+	response = request("a")
+	if response.Code != "429" || response.Header.Get("Retry-After") != "30" || response.Header.Get("RateLimit-Limit") != "2" {
+		t.Fatalf("got %s %v", response.Code, response.Header)
+	}
+	if response := request("b"); response.Code != "200" {
+		t.Fatalf("other key: got %s", response.Code)
+	}
+
+	custom := Chain(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, RateLimitMiddleware(RateLimitConfig{RateLimitRule: RateLimitRule{Limit: 1}, Response: func(request *Request, result RateLimitResult) *Response {
+		return Build503Response()
+	}}))
+	custom(newTestRequest(t, "GET", "/"))
+	if response := custom(newTestRequest(t, "GET", "/")); response.Code != "503" || response.Header.Get("Retry-After") == "" {
+		t.Fatalf("custom response: got %s %v", response.Code, response.Header)
+	}
+
+	streamed := Chain(func(request *Request) *Response {
+		response := BuildBasicResponse()
+		response.sendedHeader = true //模拟处理函数自己调用了SendHeader
+		return response
+	}, RateLimitMiddleware(RateLimitConfig{RateLimitRule: RateLimitRule{Limit: 1}}))
+	if response := streamed(newTestRequest(t, "GET", "/")); response.Header.Has("RateLimit-Limit") {
+		t.Fatalf("headers added after they were sent: %v", response.Header)
+	}
+
+	unlimited := Chain(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, RateLimitMiddleware(RateLimitConfig{RateLimitRule: RateLimitRule{Limit: 1}, KeyFunc: func(*Request) string { return "" }}))
+	failing := Chain(func(request *Request) *Response {
+		return BuildBasicResponse()
+	}, RateLimitMiddleware(RateLimitConfig{RateLimitRule: RateLimitRule{Limit: 1}, Store: failingRateLimitStore{}}))
+	for i := 0; i < 3; i++ {
+		if response := unlimited(newTestRequest(t, "GET", "/")); response.Code != "200" {
+			t.Fatalf("empty key: got %s", response.Code)
+		}
+		if response := failing(newTestRequest(t, "GET", "/")); response.Code != "200" {
+			t.Fatalf("store error should fail open, got %s", response.Code)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package simpwebserv
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var redirectCodeNames = map[string]string{
+	"301": "Moved Permanently",
+	"302": "Found",
+	"303": "See Other",
+	"307": "Temporary Redirect",
+	"308": "Permanent Redirect",
+}
+
+func BuildRedirectResponse(location string, code string) *Response { //创建重定向响应，code为301、302、303、307、308
+	response := BuildBasicResponse()
+	response.Code = code
+	response.CodeName = redirectCodeNames[code]
+	response.Header.Set("Location", location)
+	response.Header.Del("Content-Type")
+	return response
+}
+
+func validRedirectHost(host string) bool { //只允许域名、IP和端口里会出现的字符，防止被构造成跳到别的地方
+	if host == "" {
+		return false
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == ':' || c == '[' || c == ']') {
+			return false
+		}
+	}
+	return true
+}
+
+func buildHttpsRedirectResponse(request *Request, httpsPort uint16) *Response { //把请求重定向到HTTPS，保留路径和参数
+	host := request.Header.Get("Host")
+	if !validRedirectHost(host) {
+		return Build400Response()
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+	}
+	if httpsPort != 443 {
+		host += ":" + strconv.Itoa(int(httpsPort))
+	}
+	location := "https://" + host + request.Path
+	if request.UrlParameter != "" {
+		location += "?" + request.UrlParameter
+	}
+	if request.Method == "GET" || request.Method == "HEAD" {
+		return BuildRedirectResponse(location, "301")
+	}
+	return BuildRedirectResponse(location, "308") //308不会把POST改成GET
+}
+
+func (app *AppStruct) newRedirectApp(httpsPort uint16) *AppStruct { //创建只做HTTPS重定向的明文实例，ACME的HTTP-01验证也在这里回复
+	redirectApp := App()
+	redirectApp.enableConsoleLog = app.enableConsoleLog
+	redirectApp.enableKeepAlive = app.enableKeepAlive
+	redirectApp.idleTimeout = app.idleTimeout
+	redirectApp.readHeaderTimeout = app.readHeaderTimeout
+	redirectApp.readTimeout = app.readTimeout
+	redirectApp.writeTimeout = app.writeTimeout
+	redirectApp.connLimiter = app.connLimiter //连接数和主实例一起算
+	redirectApp.minTransferRate = app.minTransferRate
+	redirectApp.maxHeaderCount = app.maxHeaderCount
+	redirectApp.maxHeaderBytes = app.maxHeaderBytes
+	redirectApp.acmeManager = app.acmeManager
+	redirectApp.handler = func(request *Request) *Response {
+		return buildHttpsRedirectResponse(request, httpsPort)
+	}
+	return redirectApp
+}
+
+func buildHSTSHeader(maxAge time.Duration, includeSubdomains bool, preload bool) string { //生成Strict-Transport-Security的值
+	value := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+	return value
+}
+
+func (app *AppStruct) SetHSTS(maxAge time.Duration, includeSubdomains bool, preload bool) { //设置HSTS，TLS连接上的所有响应都会带上Strict-Transport-Security
+	if maxAge <= 0 {
+		app.hstsHeader = ""
+		return
+	}
+	app.hstsHeader = buildHSTSHeader(maxAge, includeSubdomains, preload)
+}
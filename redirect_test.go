@@ -0,0 +1,67 @@
+package simpwebserv
+
+import (
+	"bufio"
+	"testing"
+	"time"
+)
+
+func TestHttpsRedirect(t *testing.T) {
+	tests := []struct {
+		method    string
+		host      string
+		path      string
+		parameter string
+		port      uint16
+		code      string
+		location  string
+	}{
+		{"GET", "example.com", "/a/b", "x=1", 443, "301", "https://example.com/a/b?x=1"},
+		{"HEAD", "example.com:80", "/", "", 443, "301", "https://example.com/"},
+		{"POST", "example.com", "/form", "", 443, "308", "https://example.com/form"},
+		{"PUT", "example.com:8080", "/", "", 8443, "308", "https://example.com:8443/"},
+		{"DELETE", "[::1]:8080", "/", "", 443, "308", "https://[::1]/"},
+		{"GET", "[::1]:8080", "/", "", 8443, "301", "https://[::1]:8443/"},
+		{"GET", "", "/", "", 443, "400", ""},
+		{"GET", "evil.com/@example.com", "/", "", 443, "400", ""},
+		{"GET", "example.com\\evil.com", "/", "", 443, "400", ""},
+		{"GET", "user@example.com", "/", "", 443, "400", ""},
+		{"GET", "example.com?x", "/", "", 443, "400", ""},
+	}
+	for _, test := range tests {
+		request := newTestRequest(t, test.method, test.path)
+		request.UrlParameter = test.parameter
+		if test.host != "" {
+			request.Header.Set("Host", test.host)
+		}
+		response := buildHttpsRedirectResponse(request, test.port)
+		if response.Code != test.code || response.Header.Get("Location") != test.location {
+			t.Fatalf("%s %q: got %s %q, want %s %q", test.method, test.host, response.Code, response.Header.Get("Location"), test.code, test.location)
+		}
+	}
+}
+
+func TestRedirectAppOnWire(t *testing.T) {
+	app := App()
+	client := servePipe(t, app.newRedirectApp(8443))
+	go client.Write([]byte("POST /submit HTTP/1.1\r\nHost: example.com\r\nContent-Length: 0\r\n\r\n"))
+	response, _ := readTestResponse(t, bufio.NewReader(client))
+	if response.StatusCode != 308 || response.Header.Get("Location") != "https://example.com:8443/submit" {
+		t.Fatalf("got %d %q", response.StatusCode, response.Header.Get("Location"))
+	}
+}
+
+func TestHSTSHeader(t *testing.T) {
+	if header := buildHSTSHeader(365*24*time.Hour, true, true); header != "max-age=31536000; includeSubDomains; preload" {
+		t.Fatalf("got %q", header)
+	}
+	app := App()
+	app.SetHSTS(time.Hour, false, false)
+	if app.hstsHeader != "max-age=3600" {
+		t.Fatalf("got %q", app.hstsHeader)
+	}
+	app.SetHSTS(0, true, true)
+	if app.hstsHeader != "" {
+		t.Fatal("a zero max-age should turn HSTS off")
+	}
+}
@@ -13,28 +13,59 @@ import (
 )
 
 func (request *Request) ConnRead(buf []byte) (int, error) {
-	if !bytes.Equal(request.readRestData, []byte{}) {
-		if cap(buf) <= len(request.readRestData) {
-			buf = request.readRestData[0:cap(buf)]
-			request.readRestData = request.readRestData[:len(request.readRestData)]
-			return cap(buf), nil
-		} else {
-			newBuf := make([]byte, cap(buf)-len(request.readRestData))
-			i, err := request.conn.Read(newBuf)
-			request.bodyReaded += uint64(i)
-			buf = append(request.readRestData, newBuf...)
-			request.readRestData = []byte{}
-			return len(buf), err
-		}
+	if len(request.readRestData) != 0 { //先读之前多读出来的数据
+		n := copy(buf, request.readRestData)
+		request.readRestData = request.readRestData[n:]
+		return n, nil
 	}
-	if request.enableKeepAlive {
-		request.conn.SetReadDeadline(time.Now().Add(time.Second * request.keepAliveTimeout))
+	deadline := timeoutDeadline(request.readTimeout)
+	if request.minTransferRate > 0 { //从第一次读body开始按最低速率计算
+		if request.bodyReadStart.IsZero() {
+			request.bodyReadStart = time.Now()
+		}
+		deadline = earlierDeadline(deadline, rateDeadline(request.bodyReadStart, request.bodyReaded, request.minTransferRate))
 	}
+	request.conn.SetReadDeadline(deadline)
 	i, err := request.conn.Read(buf)
 	request.bodyReaded += uint64(i)
+	if isTimeoutError(err) { //处理函数还没发响应的话会改成408
+		request.readTimedOut = true
+	}
+	if request.bodyReaded >= request.bodyBytes {
+		request.watchDisconnect()
+	}
 	return i, err
 }
 
+func (request *Request) readBody(length uint64) ([]byte, error) { //从body里读出length个字节
+	buffer := make([]byte, length)
+	var readed uint64
+	for readed < length {
+		n, err := request.ConnRead(buffer[readed:])
+		readed += uint64(n)
+		if err != nil {
+			return buffer[:readed], err
+		}
+	}
+	return buffer, nil
+}
+
+func (request *Request) discardBody() error { //丢掉处理函数没读完的body，给keep-alive的下一个请求用
+	request.readRestData = []byte{}
+	buffer := make([]byte, fileSendBufferSize)
+	for request.bodyBytes > request.bodyReaded {
+		rest := request.bodyBytes - request.bodyReaded
+		if rest > uint64(len(buffer)) {
+			rest = uint64(len(buffer))
+		}
+		_, err := request.ConnRead(buffer[:rest])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (request *Request) ConnReadUntil(spliter []byte, writer io.Writer, maxSize uint64) error {
 	readBuffer1 := make([]byte, bufferMaxSize)
 	readBuffer2 := make([]byte, bufferMaxSize)
@@ -61,18 +92,27 @@ func (request *Request) ConnReadUntil(spliter []byte, writer io.Writer, maxSize
 	return nil
 }
 
-func (request *Request) ConnWrite(buf []byte) (int, error) {
+func (request *Request) ConnWrite(buf []byte) (int, error) { //写body，会计入访问日志的字节数
+	i, err := request.writeConn(buf)
+	request.bytesSent += uint64(i)
+	return i, err
+}
+
+func (request *Request) writeConn(buf []byte) (int, error) {
+	request.conn.SetWriteDeadline(timeoutDeadline(request.writeTimeout))
 	i, err := request.conn.Write(buf)
 	return i, err
 }
 
 func (request *Request) SendHeader(response *Response) {
-	request.conn.Write([]byte(response.Protocol + " " + response.Code + " " + response.CodeName + "\r\n"))
-
-	for k, v := range response.Header {
-		request.conn.Write([]byte(k + ": " + v + "\r\n"))
+	var builder strings.Builder
+	builder.WriteString(response.Protocol + " " + response.Code + " " + response.CodeName + "\r\n")
+	response.Header.write(&builder)
+	for i := 0; i < len(response.SetCookieList); i++ {
+		builder.WriteString("Set-Cookie: " + headerValueReplacer.Replace(response.SetCookieList[i]) + "\r\n")
 	}
-	request.conn.Write([]byte("\r\n"))
+	builder.WriteString("\r\n")
+	request.writeConn([]byte(builder.String()))
 	response.sendedHeader = true
 }
 
@@ -89,17 +129,22 @@ func (request *Request) DecodeUrlParameter() map[string]string {
 	return parameterMap
 }
 
-func (request *Request) DecodeFormUrlEncoded() (map[string]string, error) {
+func (request *Request) DecodeFormUrlEncoded() (map[string]string, error) { //解析表单，body只能读一次，所以结果会缓存下来（CSRF中间件读过之后处理函数还能再调用）
+	if request.form != nil {
+		return request.form, nil
+	}
 	if request.Method == "POST" {
-		if contentType, ok := request.Header["Content-Type"]; ok {
-			if contentType == "application/x-www-form-urlencoded" {
-				if contentLengthString, ok := request.Header["Content-Length"]; ok {
+		if contentType, ok := request.Header.Lookup("Content-Type"); ok {
+			if strings.TrimSpace(strings.Split(contentType, ";")[0]) == "application/x-www-form-urlencoded" {
+				if contentLengthString, ok := request.Header.Lookup("Content-Length"); ok {
 					contentLength, err := strconv.ParseUint(contentLengthString, 10, 64)
 					if err != nil {
 						return nil, err
 					}
-					buffer := make([]byte, contentLength)
-					_, err = request.ConnRead(buffer)
+					if contentLength > maxFormSize {
+						return nil, ErrBufferTooBig
+					}
+					buffer, err := request.readBody(contentLength)
 					if err != nil {
 						return nil, err
 					}
@@ -114,6 +159,7 @@ func (request *Request) DecodeFormUrlEncoded() (map[string]string, error) {
 							dataMap[first], _ = url.PathUnescape(parameterSplit[1])
 						}
 					}
+					request.form = dataMap
 					return dataMap, nil
 				}
 			}
@@ -135,11 +181,11 @@ func (request *Request) SendFile(response *Response, path string, filename strin
 		return
 	}
 
-	response.Header["Content-Type"] = "application/octet-stream"
-	response.Header["Content-Disposition"] = "attachment; filename=" + filename
+	response.Header.Set("Content-Type", "application/octet-stream")
+	response.Header.Set("Content-Disposition", "attachment; filename="+filename)
 
 	if fileStat.IsDir() {
-		response.Header["Transfer-Encoding"] = "chunked"
+		response.Header.Set("Transfer-Encoding", "chunked")
 		if request.Method != "HEAD" {
 			pipeReader, pipeWriter := io.Pipe()
 			sendBuffer := make([]byte, fileSendBufferSize)
@@ -202,13 +248,13 @@ func (request *Request) SendFile(response *Response, path string, filename strin
 			return
 		}
 	} else {
-		response.Header["Accept-Ranges"] = "bytes"
+		response.Header.Set("Accept-Ranges", "bytes")
 		fileSize := fileStat.Size()
-		response.Header["Content-Length"] = strconv.FormatInt(fileSize, 10)
+		response.Header.Set("Content-Length", strconv.FormatInt(fileSize, 10))
 		if request.Method != "HEAD" {
 			var ok bool
 			var requestRangeString string
-			if requestRangeString, ok = request.Header["Range"]; ok {
+			if requestRangeString, ok = request.Header.Lookup("Range"); ok {
 				response.Code = "206"
 				response.CodeName = "Partial Content"
 				requestRangeString = strings.Split(requestRangeString, "=")[1]
@@ -244,9 +290,9 @@ func (request *Request) SendFile(response *Response, path string, filename strin
 					response.CodeName = "Partial Content"
 					return
 				}
-				response.Header["Content-Range"] = "bytes " + strconv.FormatInt(startPos, 10) + "-" + strconv.FormatInt(endPos-1, 10) + "/" + strconv.FormatInt(fileSize, 10)
+				response.Header.Set("Content-Range", "bytes "+strconv.FormatInt(startPos, 10)+"-"+strconv.FormatInt(endPos-1, 10)+"/"+strconv.FormatInt(fileSize, 10))
 				restDataLength := endPos - startPos
-				response.Header["Content-Length"] = strconv.FormatInt(restDataLength, 10)
+				response.Header.Set("Content-Length", strconv.FormatInt(restDataLength, 10))
 				f.Seek(startPos, io.SeekStart)
 				buffer := make([]byte, fileSendBufferSize)
 				var n int
@@ -316,7 +362,7 @@ func (request *Request) SendFile(response *Response, path string, filename strin
 
 func (request *Request) RecvFile(storePath string, filename string, maxSize uint64) error {
 	if request.Method == "POST" {
-		if contentType, ok := request.Header["Content-Type"]; ok {
+		if contentType, ok := request.Header.Lookup("Content-Type"); ok {
 			contentTypeSplit := strings.Split(contentType, "; ")
 			if contentTypeSplit[0] == "multipart/form-data" {
 				boundarySplit := strings.Split(contentTypeSplit[1], "=")
@@ -332,11 +378,9 @@ func (request *Request) RecvFile(storePath string, filename string, maxSize uint
 				var first string
 				var second string
 				var i int
-				partHeaderMap := make(map[string]string)
+				partHeaderMap := make(Header)
 				for {
-					if request.enableKeepAlive {
-						request.conn.SetReadDeadline(time.Now().Add(time.Second * request.keepAliveTimeout))
-					}
+					request.conn.SetReadDeadline(timeoutDeadline(request.readTimeout))
 					httpReadHeaderLine(request.conn, &buffer, recv, &first, &second, &err, &i)
 					if err != nil {
 						return err
@@ -344,9 +388,9 @@ func (request *Request) RecvFile(storePath string, filename string, maxSize uint
 					if first == "" || second == "" {
 						break
 					}
-					partHeaderMap[first] = second
+					partHeaderMap.Add(first, strings.TrimSpace(second))
 				}
-				if contentDisposition, ok := partHeaderMap["Content-Disposition"]; ok {
+				if contentDisposition, ok := partHeaderMap.Lookup("Content-Disposition"); ok {
 					contentDispositionSplit := strings.Split(contentDisposition, "; ")
 					contentDispositionSplit = contentDispositionSplit[1:]
 					var attributeSplit []string
@@ -0,0 +1,100 @@
+package simpwebserv
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	RequestIDHeader       = "X-Request-ID"
+	maxRequestIDLength    = 128
+	crockfordBase32Digits = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+type RequestIDConfig struct { //请求ID中间件的配置
+	Generator      func() string     //生成新的请求ID，默认为NewUUIDv7
+	Validator      func(string) bool //检查请求里带的ID能不能用，默认为ValidRequestID
+	IgnoreIncoming bool              //不使用请求里的X-Request-ID，总是生成新的（比如前面没有可信的代理时）
+}
+
+func (request *Request) RequestID() string { //请求ID，没有使用RequestIDMiddleware时为空字符串
+	return request.requestID
+}
+
+func ValidRequestID(id string) bool { //长度1到128，只能有字母、数字和-_.:+/=，防止伪造日志
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' && c != ':' && c != '+' && c != '/' && c != '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func NewUUIDv7() string { //按时间排序的UUID（RFC 9562），前48位是毫秒时间戳
+	var uuid [16]byte
+	milli := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(uuid[0:2], uint16(milli>>32))
+	binary.BigEndian.PutUint32(uuid[2:6], uint32(milli))
+	rand.Read(uuid[6:])
+	uuid[6] = uuid[6]&0x0f | 0x70 //版本7
+	uuid[8] = uuid[8]&0x3f | 0x80 //RFC 4122变体
+	buffer := make([]byte, 36)
+	hex.Encode(buffer[0:8], uuid[0:4])
+	buffer[8] = '-'
+	hex.Encode(buffer[9:13], uuid[4:6])
+	buffer[13] = '-'
+	hex.Encode(buffer[14:18], uuid[6:8])
+	buffer[18] = '-'
+	hex.Encode(buffer[19:23], uuid[8:10])
+	buffer[23] = '-'
+	hex.Encode(buffer[24:], uuid[10:])
+	return string(buffer)
+}
+
+func NewULID() string { //26个字符的ULID，前48位是毫秒时间戳，后80位随机，用Crockford Base32编码
+	var ulid [16]byte
+	milli := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(ulid[0:2], uint16(milli>>32))
+	binary.BigEndian.PutUint32(ulid[2:6], uint32(milli))
+	rand.Read(ulid[6:])
+	high := binary.BigEndian.Uint64(ulid[0:8])
+	low := binary.BigEndian.Uint64(ulid[8:16])
+	buffer := make([]byte, 26)
+	for i := 25; i >= 0; i-- { //128位从低到高每次取5位，最高的一个字符只有3位
+		buffer[i] = crockfordBase32Digits[low&0x1f]
+		low = low>>5 | high<<59
+		high >>= 5
+	}
+	return string(buffer)
+}
+
+func RequestIDMiddleware(config RequestIDConfig) Middleware { //给每个请求分配ID，放到响应的X-Request-ID里，访问日志和debug模式的500页面也会带上
+	if config.Generator == nil {
+		config.Generator = NewUUIDv7
+	}
+	if config.Validator == nil {
+		config.Validator = ValidRequestID
+	}
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			id := ""
+			if !config.IgnoreIncoming {
+				id = request.Header.Get(RequestIDHeader)
+			}
+			if id == "" || !config.Validator(id) {
+				id = config.Generator()
+			}
+			request.requestID = id
+			request.Span().SetAttribute("http.request.id", id)
+			response := next(request)
+			response.Header.Set(RequestIDHeader, id)
+			return response
+		}
+	}
+}
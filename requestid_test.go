@@ -0,0 +1,130 @@
+package simpwebserv
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidRequestID(t *testing.T) {
+	valid := []string{"a", "0190a8e5-6f3c-7b2a-9c1d-2e3f4a5b6c7d", "01J2ZQ3X8Y", "trace:abc+def/ghi==", "a_b.c", strings.Repeat("x", maxRequestIDLength)}
+	for _, id := range valid {
+		if !ValidRequestID(id) {
+			t.Errorf("%q should be valid", id)
+		}
+	}
+	invalid := []string{"", strings.Repeat("x", maxRequestIDLength+1), "a b", "a\nb", "a\"b", "ä", "<script>"}
+	for _, id := range invalid {
+		if ValidRequestID(id) {
+			t.Errorf("%q should be invalid", id)
+		}
+	}
+}
+
+func decodeTestTimestamp(digits string, alphabet string, bits uint) int64 {
+	var stamp int64
+	for _, c := range digits {
+		stamp = stamp<<bits | int64(strings.IndexRune(alphabet, c))
+	}
+	return stamp
+}
+
+func checkTestTimestamps(t *testing.T, generate func() string, timestamp func(string) int64) { //时间戳要接近当前时间并且不递减
+	var previous int64
+	for i := 0; i < 100; i++ {
+		before := time.Now().UnixMilli()
+		id := generate()
+		stamp := timestamp(id)
+		if stamp < before || stamp > time.Now().UnixMilli() || stamp < previous {
+			t.Fatalf("%q: timestamp %d out of order", id, stamp)
+		}
+		previous = stamp
+	}
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		id := NewUUIDv7()
+		if len(id) != 36 || id[8] != '-' || id[13] != '-' || id[18] != '-' || id[23] != '-' || !isLowerHex(strings.ReplaceAll(id, "-", "")) {
+			t.Fatalf("malformed %q", id)
+		}
+		if id[14] != '7' || !strings.ContainsRune("89ab", rune(id[19])) {
+			t.Fatalf("%q: wrong version or variant", id)
+		}
+	}
+	checkTestTimestamps(t, NewUUIDv7, func(id string) int64 {
+		return decodeTestTimestamp(id[:8]+id[9:13], "0123456789abcdef", 4)
+	})
+}
+
+func TestNewULID(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		id := NewULID()
+		if len(id) != 26 || id[0] > '7' {
+			t.Fatalf("malformed %q", id)
+		}
+		for _, c := range id {
+			if !strings.ContainsRune(crockfordBase32Digits, c) {
+				t.Fatalf("%q: %q is not Crockford base32", id, c)
+			}
+		}
+	}
+	checkTestTimestamps(t, NewULID, func(id string) int64 { //前10个字符是48位毫秒时间戳
+		return decodeTestTimestamp(id[:10], crockfordBase32Digits, 5)
+	})
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := func(request *Request) *Response {
+		seen = request.RequestID()
+		return BuildBasicResponse()
+	}
+	tests := []struct {
+		name     string
+		config   RequestIDConfig
+		incoming string
+		reused   bool
+	}{
+		{"generated", RequestIDConfig{}, "", false},
+		{"reused", RequestIDConfig{}, "upstream-id", true},
+		{"invalid", RequestIDConfig{}, "bad id\r\nX-Injected: 1", false},
+		{"ignored", RequestIDConfig{IgnoreIncoming: true}, "upstream-id", false},
+		{"custom validator", RequestIDConfig{Validator: func(id string) bool { return strings.HasPrefix(id, "lb-") }}, "upstream-id", false},
+	}
+	for _, test := range tests {
+		request := newTestRequest(t, "GET", "/")
+		if test.incoming != "" {
+			request.Header.Set(RequestIDHeader, test.incoming)
+		}
+		response := Chain(handler, RequestIDMiddleware(test.config))(request)
+		if response.Header.Get(RequestIDHeader) != seen || seen == "" {
+			t.Errorf("%s: response header %q, request id %q", test.name, response.Header.Get(RequestIDHeader), seen)
+		}
+		if (seen == test.incoming) != test.reused {
+			t.Errorf("%s: got %q for incoming %q", test.name, seen, test.incoming)
+		}
+	}
+
+	request := newTestRequest(t, "GET", "/")
+	response := Chain(handler, RequestIDMiddleware(RequestIDConfig{Generator: func() string { return "fixed" }}))(request)
+	if seen != "fixed" || response.Header.Get(RequestIDHeader) != "fixed" {
+		t.Errorf("custom generator: got %q", seen)
+	}
+}
+
+func TestAccessLogRequestIDFallback(t *testing.T) {
+	request := newTestRequest(t, "GET", "/")
+	request.Header.Set(RequestIDHeader, "upstream-id")
+	if entry := newAccessLogEntry(request, BuildBasicResponse(), time.Now()); entry.RequestID != "upstream-id" {
+		t.Errorf("got %q, want the incoming header", entry.RequestID)
+	}
+	request.Header.Set(RequestIDHeader, "bad id")
+	if entry := newAccessLogEntry(request, BuildBasicResponse(), time.Now()); entry.RequestID != "" {
+		t.Errorf("got %q, an invalid header must not reach the log", entry.RequestID)
+	}
+	request.requestID = "assigned"
+	if entry := newAccessLogEntry(request, BuildBasicResponse(), time.Now()); entry.RequestID != "assigned" {
+		t.Errorf("got %q, want the middleware's id", entry.RequestID)
+	}
+}
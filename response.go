@@ -4,49 +4,162 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 )
 
+func formatGMTTime(t time.Time) string { //格式化成HTTP用的GMT时间
+	utcTime := t.UTC().Format(time.RFC1123)
+	return utcTime[:len(utcTime)-3] + "GMT"
+}
+
 func getGMTTime(offset string) string { //获取GMT时间
 	now := time.Now().UTC()
 	t, err := time.ParseDuration(offset)
 	if err == nil {
 		now = now.Add(t)
 	}
-	utcTime := now.Format(time.RFC1123)
-	return utcTime[:len(utcTime)-3] + "GMT"
+	return formatGMTTime(now)
+}
+
+func responseHasBody(code string) bool { //1xx、204、304的响应不能带body，也不能带Content-Length
+	return !(strings.HasPrefix(code, "1") || code == "204" || code == "304")
 }
 
 func BuildBasicResponse() *Response { //创建200的默认的响应
-	response := Response{"HTTP/1.1", "200", "OK", make(map[string]string), new(bytes.Buffer), make([]string, 0), false}
-	response.Header["Date"] = getGMTTime("")
-	response.Header["Content-Type"] = "text/html; charset=utf-8"
-	response.Header["Connection"] = "keep-alive"
+	response := Response{"HTTP/1.1", "200", "OK", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "keep-alive")
+	return &response
+}
+
+func Build400DefaultResponse() *Response { //创建400的默认响应
+	response := Response{"HTTP/1.1", "400", "Bad Request", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "keep-alive")
+	response.Body.WriteString(default400Page)
+	return &response
+}
+
+func Build401DefaultResponse() *Response { //创建401的默认响应
+	response := Response{"HTTP/1.1", "401", "Unauthorized", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "keep-alive")
+	response.Body.WriteString(default401Page)
+	return &response
+}
+
+func Build403DefaultResponse() *Response { //创建403的默认响应
+	response := Response{"HTTP/1.1", "403", "Forbidden", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "keep-alive")
+	response.Body.WriteString(default403Page)
 	return &response
 }
 
 func Build404DefaultResponse() *Response { //创建404的默认响应
-	response := Response{"HTTP/1.1", "404", "Not Found", make(map[string]string), new(bytes.Buffer), make([]string, 0), false}
-	response.Header["Date"] = getGMTTime("")
-	response.Header["Content-Type"] = "text/html; charset=utf-8"
-	response.Header["Connection"] = "keep-alive"
+	response := Response{"HTTP/1.1", "404", "Not Found", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "keep-alive")
 	response.Body.WriteString(default404Page)
 	return &response
 }
 
+func Build408DefaultResponse() *Response { //创建408的默认响应
+	response := Response{"HTTP/1.1", "408", "Request Timeout", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "close")
+	response.Body.WriteString(default408Page)
+	return &response
+}
+
+func Build429DefaultResponse() *Response { //创建429的默认响应
+	response := Response{"HTTP/1.1", "429", "Too Many Requests", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "keep-alive")
+	response.Body.WriteString(default429Page)
+	return &response
+}
+
+func Build431DefaultResponse() *Response { //创建431的默认响应
+	response := Response{"HTTP/1.1", "431", "Request Header Fields Too Large", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "close")
+	response.Body.WriteString(default431Page)
+	return &response
+}
+
 func Build500DefaultResponse() *Response { //创建500的默认响应
-	response := Response{"HTTP/1.1", "500", "Internal Server Error", make(map[string]string), new(bytes.Buffer), make([]string, 0), false}
-	response.Header["Date"] = getGMTTime("")
-	response.Header["Content-Type"] = "text/html; charset=utf-8"
-	response.Header["Connection"] = "close"
+	response := Response{"HTTP/1.1", "500", "Internal Server Error", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "close")
 	response.Body.WriteString(default500Page)
 	return &response
 }
 
+func Build503DefaultResponse() *Response { //创建503的默认响应
+	response := Response{"HTTP/1.1", "503", "Service Unavailable", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "keep-alive")
+	response.Body.WriteString(default503Page)
+	return &response
+}
+
+func Build504DefaultResponse() *Response { //创建504的默认响应
+	response := Response{"HTTP/1.1", "504", "Gateway Timeout", make(Header), new(bytes.Buffer), make([]string, 0), false}
+	response.Header.Set("Date", getGMTTime(""))
+	response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	response.Header.Set("Connection", "keep-alive")
+	response.Body.WriteString(default504Page)
+	return &response
+}
+
+func Build400Response() *Response { //未来自定义400页面使用
+	return Build400DefaultResponse()
+}
+
+func Build401Response() *Response { //未来自定义401页面使用
+	return Build401DefaultResponse()
+}
+
+func Build403Response() *Response { //未来自定义403页面使用
+	return Build403DefaultResponse()
+}
+
 func Build404Response() *Response { //未来自定义404页面使用
 	return Build404DefaultResponse()
 }
 
+func Build408Response() *Response { //未来自定义408页面使用
+	return Build408DefaultResponse()
+}
+
+func Build429Response() *Response { //未来自定义429页面使用
+	return Build429DefaultResponse()
+}
+
+func Build431Response() *Response { //未来自定义431页面使用
+	return Build431DefaultResponse()
+}
+
+func Build503Response() *Response { //未来自定义503页面使用
+	return Build503DefaultResponse()
+}
+
+func Build504Response() *Response { //未来自定义504页面使用
+	return Build504DefaultResponse()
+}
+
 func BuildStaticFileResponse(path string, contentType string) *Response {
 	f, err := os.Open(path)
 	if err != nil {
@@ -61,7 +174,7 @@ func BuildStaticFileResponse(path string, contentType string) *Response {
 		panic(err)
 	}
 	response := BuildBasicResponse()
-	response.Header["Content-Type"] = contentType
+	response.Header.Set("Content-Type", contentType)
 	response.Body.Write(data)
 	return response
 }
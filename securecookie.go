@@ -0,0 +1,184 @@
+package simpwebserv
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CookieKeys struct { //签名和加密cookie用的密钥，SigningKey用来签名/加密，VerifyKeys是轮换下来的旧密钥，只用来验证/解密
+	SigningKey []byte
+	VerifyKeys [][]byte
+}
+
+func NewCookieKeys(signingKey []byte, verifyKeys ...[]byte) *CookieKeys { //创建cookie密钥
+	return &CookieKeys{signingKey, verifyKeys}
+}
+
+func (keys *CookieKeys) allKeys() [][]byte { //签名密钥在前，旧密钥在后
+	allKeys := make([][]byte, 0, len(keys.VerifyKeys)+1)
+	if len(keys.SigningKey) != 0 {
+		allKeys = append(allKeys, keys.SigningKey)
+	}
+	for i := 0; i < len(keys.VerifyKeys); i++ {
+		if len(keys.VerifyKeys[i]) != 0 {
+			allKeys = append(allKeys, keys.VerifyKeys[i])
+		}
+	}
+	return allKeys
+}
+
+func deriveCookieKey(key []byte, purpose string) []byte { //同一个密钥按用途派生出不同的子密钥
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("simpwebserv cookie " + purpose))
+	return mac.Sum(nil)
+}
+
+func cookieExpireUnix(cookie *Cookie) int64 { //算出cookie在服务端的过期时间，0为不过期
+	if cookie.MaxAge > 0 {
+		return time.Now().Add(time.Duration(cookie.MaxAge) * time.Second).Unix()
+	}
+	if !cookie.Expires.IsZero() {
+		return cookie.Expires.Unix()
+	}
+	return 0
+}
+
+func signCookieValue(key []byte, name string, payload string) string {
+	mac := hmac.New(sha256.New, deriveCookieKey(key, "sign"))
+	mac.Write([]byte(name + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (response *Response) SetSignedCookie(keys *CookieKeys, cookie *Cookie) error { //设置一个带HMAC签名的cookie（内容可读但不可篡改）
+	if len(keys.SigningKey) == 0 {
+		return ErrNoCookieKey
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(cookie.Value)) + "." + strconv.FormatInt(cookieExpireUnix(cookie), 10)
+	signedCookie := *cookie
+	signedCookie.Value = payload + "." + signCookieValue(keys.SigningKey, cookie.Name, payload)
+	response.SetCookie(&signedCookie)
+	return nil
+}
+
+func (request *Request) GetSignedCookie(keys *CookieKeys, name string) (string, error) { //获取并验证一个签名cookie
+	value, ok := request.GetCookie(name)
+	if !ok {
+		return "", ErrCookieNotFound
+	}
+	index := strings.LastIndexByte(value, '.')
+	if index == -1 {
+		return "", ErrInvalidCookie
+	}
+	payload, signature := value[:index], value[index+1:]
+	verified := false
+	allKeys := keys.allKeys()
+	for i := 0; i < len(allKeys); i++ {
+		if hmac.Equal([]byte(signature), []byte(signCookieValue(allKeys[i], name, payload))) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", ErrInvalidCookie
+	}
+	payloadSplit := strings.Split(payload, ".")
+	if len(payloadSplit) != 2 {
+		return "", ErrInvalidCookie
+	}
+	expire, err := strconv.ParseInt(payloadSplit[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	if expire != 0 && time.Now().Unix() > expire {
+		return "", ErrCookieExpired
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payloadSplit[0])
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	return string(data), nil
+}
+
+func newCookieAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveCookieKey(key, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptCookieValue(key []byte, name string, value []byte, expire int64) (string, error) { //AES-256-GCM加密，cookie名作为附加数据
+	aead, err := newCookieAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	plaintext := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(plaintext, uint64(expire))
+	plaintext = append(plaintext, value...)
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, []byte(name))), nil
+}
+
+func decryptCookieValue(keys *CookieKeys, name string, value string) ([]byte, error) { //依次用每个密钥尝试解密
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	allKeys := keys.allKeys()
+	for i := 0; i < len(allKeys); i++ {
+		aead, err := newCookieAEAD(allKeys[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize()+aead.Overhead()+8 {
+			return nil, ErrInvalidCookie
+		}
+		plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name))
+		if err != nil {
+			continue
+		}
+		expire := int64(binary.BigEndian.Uint64(plaintext[:8]))
+		if expire != 0 && time.Now().Unix() > expire {
+			return nil, ErrCookieExpired
+		}
+		return plaintext[8:], nil
+	}
+	return nil, ErrInvalidCookie
+}
+
+func (response *Response) SetEncryptedCookie(keys *CookieKeys, cookie *Cookie) error { //设置一个加密的cookie（内容不可读也不可篡改）
+	if len(keys.SigningKey) == 0 {
+		return ErrNoCookieKey
+	}
+	value, err := encryptCookieValue(keys.SigningKey, cookie.Name, []byte(cookie.Value), cookieExpireUnix(cookie))
+	if err != nil {
+		return err
+	}
+	encryptedCookie := *cookie
+	encryptedCookie.Value = value
+	response.SetCookie(&encryptedCookie)
+	return nil
+}
+
+func (request *Request) GetEncryptedCookie(keys *CookieKeys, name string) (string, error) { //获取并解密一个加密cookie
+	value, ok := request.GetCookie(name)
+	if !ok {
+		return "", ErrCookieNotFound
+	}
+	data, err := decryptCookieValue(keys, name, value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
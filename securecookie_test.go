@@ -0,0 +1,146 @@
+package simpwebserv
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func roundTripCookie(t *testing.T, set func(*Response) error, get func(*Request) (string, error)) (string, error) {
+	response := BuildBasicResponse()
+	if err := set(response); err != nil {
+		t.Fatalf("set cookie: %v", err)
+	}
+	request := newTestRequest(t, "GET", "/")
+	request.Header.Set("Cookie", cookieHeaderFrom(response))
+	return get(request)
+}
+
+func TestSignedCookieRoundTrip(t *testing.T) {
+	keys := NewCookieKeys([]byte("signing key"))
+	value, err := roundTripCookie(t, func(response *Response) error {
+		return response.SetSignedCookie(keys, &Cookie{Name: "user", Value: "alice; admin=1"})
+	}, func(request *Request) (string, error) {
+		return request.GetSignedCookie(keys, "user")
+	})
+	if err != nil || value != "alice; admin=1" {
+		t.Fatalf("got %q, %v", value, err)
+	}
+}
+
+func TestSignedCookieTampered(t *testing.T) {
+	keys := NewCookieKeys([]byte("signing key"))
+	response := BuildBasicResponse()
+	response.SetSignedCookie(keys, &Cookie{Name: "user", Value: "alice"})
+	pair := cookieHeaderFrom(response)
+	tests := map[string]string{
+		"payload":   strings.Replace(pair, "user=YWxpY2U", "user=Ym9i", 1), //alice换成bob
+		"signature": pair[:len(pair)-2] + "AA",
+		"no dot":    "user=YWxpY2U",
+		"renamed":   strings.Replace(pair, "user=", "admin=", 1),
+	}
+	for name, header := range tests {
+		request := newTestRequest(t, "GET", "/")
+		request.Header.Set("Cookie", header)
+		cookieName := "user"
+		if name == "renamed" {
+			cookieName = "admin"
+		}
+		if value, err := request.GetSignedCookie(keys, cookieName); err != ErrInvalidCookie {
+			t.Errorf("%s: got %q, %v, want ErrInvalidCookie", name, value, err)
+		}
+	}
+}
+
+func TestSignedCookieKeyRotation(t *testing.T) {
+	oldKeys := NewCookieKeys([]byte("old key"))
+	response := BuildBasicResponse()
+	response.SetSignedCookie(oldKeys, &Cookie{Name: "user", Value: "alice"})
+
+	request := newTestRequest(t, "GET", "/")
+	request.Header.Set("Cookie", cookieHeaderFrom(response))
+	if value, err := request.GetSignedCookie(NewCookieKeys([]byte("new key"), []byte("old key")), "user"); err != nil || value != "alice" {
+		t.Errorf("rotated key: got %q, %v", value, err)
+	}
+	if _, err := request.GetSignedCookie(NewCookieKeys([]byte("new key")), "user"); err != ErrInvalidCookie {
+		t.Errorf("dropped key: got %v, want ErrInvalidCookie", err)
+	}
+}
+
+func TestSignedCookieExpired(t *testing.T) {
+	keys := NewCookieKeys([]byte("signing key"))
+	_, err := roundTripCookie(t, func(response *Response) error {
+		return response.SetSignedCookie(keys, &Cookie{Name: "user", Value: "alice", Expires: time.Now().Add(-time.Minute)})
+	}, func(request *Request) (string, error) {
+		return request.GetSignedCookie(keys, "user")
+	})
+	if err != ErrCookieExpired {
+		t.Fatalf("got %v, want ErrCookieExpired", err)
+	}
+}
+
+func TestSignedCookieNoKey(t *testing.T) {
+	if err := BuildBasicResponse().SetSignedCookie(NewCookieKeys(nil), &Cookie{Name: "user", Value: "alice"}); err != ErrNoCookieKey {
+		t.Fatalf("got %v, want ErrNoCookieKey", err)
+	}
+}
+
+func TestEncryptedCookieRoundTrip(t *testing.T) {
+	keys := NewCookieKeys([]byte("encryption key"))
+	response := BuildBasicResponse()
+	if err := response.SetEncryptedCookie(keys, &Cookie{Name: "secret", Value: "card=4111", MaxAge: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(response.SetCookieList[0], "4111") {
+		t.Fatalf("plaintext visible in %q", response.SetCookieList[0])
+	}
+	request := newTestRequest(t, "GET", "/")
+	request.Header.Set("Cookie", cookieHeaderFrom(response))
+	if value, err := request.GetEncryptedCookie(keys, "secret"); err != nil || value != "card=4111" {
+		t.Fatalf("got %q, %v", value, err)
+	}
+	if value, err := request.GetEncryptedCookie(NewCookieKeys([]byte("new key"), []byte("encryption key")), "secret"); err != nil || value != "card=4111" {
+		t.Fatalf("rotated key: got %q, %v", value, err)
+	}
+	if _, err := request.GetEncryptedCookie(NewCookieKeys([]byte("other key")), "secret"); err != ErrInvalidCookie {
+		t.Fatalf("wrong key: got %v, want ErrInvalidCookie", err)
+	}
+}
+
+func TestEncryptedCookieBoundToName(t *testing.T) { //cookie名是附加数据，换个名字就解不开
+	keys := NewCookieKeys([]byte("encryption key"))
+	response := BuildBasicResponse()
+	response.SetEncryptedCookie(keys, &Cookie{Name: "a", Value: "value"})
+	request := newTestRequest(t, "GET", "/")
+	request.Header.Set("Cookie", "b="+strings.TrimPrefix(cookieHeaderFrom(response), "a="))
+	if _, err := request.GetEncryptedCookie(keys, "b"); err != ErrInvalidCookie {
+		t.Fatalf("got %v, want ErrInvalidCookie", err)
+	}
+}
+
+func TestEncryptedCookieMalformed(t *testing.T) {
+	keys := NewCookieKeys([]byte("encryption key"))
+	for _, value := range []string{"", "not base64!", "c2hvcnQ"} {
+		request := newTestRequest(t, "GET", "/")
+		request.Header.Set("Cookie", "secret="+value)
+		if _, err := request.GetEncryptedCookie(keys, "secret"); err != ErrInvalidCookie {
+			t.Errorf("%q: got %v, want ErrInvalidCookie", value, err)
+		}
+	}
+	request := newTestRequest(t, "GET", "/")
+	if _, err := request.GetEncryptedCookie(keys, "secret"); err != ErrCookieNotFound {
+		t.Errorf("missing: got %v, want ErrCookieNotFound", err)
+	}
+}
+
+func TestEncryptedCookieExpired(t *testing.T) {
+	keys := NewCookieKeys([]byte("encryption key"))
+	_, err := roundTripCookie(t, func(response *Response) error {
+		return response.SetEncryptedCookie(keys, &Cookie{Name: "secret", Value: "x", Expires: time.Now().Add(-time.Minute)})
+	}, func(request *Request) (string, error) {
+		return request.GetEncryptedCookie(keys, "secret")
+	})
+	if err != ErrCookieExpired {
+		t.Fatalf("got %v, want ErrCookieExpired", err)
+	}
+}
@@ -0,0 +1,455 @@
+package simpwebserv
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionCookieName      = "session_id"
+	defaultSessionIdleTimeout     = 30 * time.Minute
+	defaultSessionAbsoluteTimeout = 24 * time.Hour
+	maxSessionCookieSize          = 4000
+)
+
+func generateRandomBytes(byteLength int) []byte { //生成随机字节
+	buffer := make([]byte, byteLength)
+	if _, err := rand.Read(buffer); err != nil {
+		panic(err)
+	}
+	return buffer
+}
+
+func generateRandomString(byteLength int) string { //生成base64url编码的随机字符串
+	return base64.RawURLEncoding.EncodeToString(generateRandomBytes(byteLength))
+}
+
+type Session struct { //单个会话
+	ID         string
+	Values     map[string]string
+	CreatedAt  time.Time
+	LastAccess time.Time
+	isNew      bool
+	modified   bool
+	destroyed  bool
+	oldID      string
+}
+
+type sessionRecord struct { //会话序列化后的格式
+	ID         string            `json:"id"`
+	Values     map[string]string `json:"values"`
+	CreatedAt  int64             `json:"created_at"`
+	LastAccess int64             `json:"last_access"`
+	Expire     int64             `json:"expire"`
+}
+
+func newSession() *Session {
+	now := time.Now()
+	return &Session{generateRandomString(32), make(map[string]string), now, now, true, false, false, ""}
+}
+
+func encodeSession(session *Session, expire time.Time) ([]byte, error) {
+	return json.Marshal(sessionRecord{session.ID, session.Values, session.CreatedAt.Unix(), session.LastAccess.Unix(), expire.Unix()})
+}
+
+func decodeSession(data []byte) (*Session, time.Time, error) {
+	var record sessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, time.Time{}, err
+	}
+	if record.Values == nil {
+		record.Values = make(map[string]string)
+	}
+	return &Session{record.ID, record.Values, time.Unix(record.CreatedAt, 0), time.Unix(record.LastAccess, 0), false, false, false, ""}, time.Unix(record.Expire, 0), nil
+}
+
+func (session *Session) Get(key string) (string, bool) { //获取值
+	value, ok := session.Values[key]
+	return value, ok
+}
+
+func (session *Session) Set(key string, value string) { //设置值
+	session.Values[key] = value
+	session.modified = true
+}
+
+func (session *Session) Delete(key string) { //删除值
+	delete(session.Values, key)
+	session.modified = true
+}
+
+func (session *Session) Clear() { //清空所有值
+	session.Values = make(map[string]string)
+	session.modified = true
+}
+
+func (session *Session) Regenerate() { //换一个新的会话ID（登录或权限变化时调用，防止会话固定攻击）
+	if session.oldID == "" && !session.isNew {
+		session.oldID = session.ID
+	}
+	session.ID = generateRandomString(32)
+	session.modified = true
+}
+
+func (session *Session) Destroy() { //销毁会话（登出时调用）
+	session.destroyed = true
+}
+
+func (request *Request) GetSession() *Session { //获取当前请求的会话，没有用SessionMiddleware时返回nil
+	return request.session
+}
+
+type SessionStore interface { //会话存储，cookieValue是浏览器cookie里保存的值
+	Load(cookieValue string) (*Session, error)               //不存在或已过期返回nil, nil
+	Save(session *Session, expire time.Time) (string, error) //返回要写进cookie的值
+	Delete(session *Session) error
+}
+
+type MemorySessionStore struct { //内存会话存储
+	lock     sync.Mutex
+	sessions map[string]memorySessionEntry
+	stop     chan struct{}
+}
+
+type memorySessionEntry struct {
+	data   []byte
+	expire time.Time
+}
+
+func NewMemorySessionStore(gcInterval time.Duration) *MemorySessionStore { //创建内存会话存储，每gcInterval清理一次过期会话
+	store := &MemorySessionStore{sessions: make(map[string]memorySessionEntry), stop: make(chan struct{})}
+	if gcInterval > 0 {
+		go store.gcLoop(gcInterval)
+	}
+	return store
+}
+
+func (store *MemorySessionStore) gcLoop(gcInterval time.Duration) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			store.GC()
+		case <-store.stop:
+			return
+		}
+	}
+}
+
+func (store *MemorySessionStore) GC() { //清理过期会话
+	now := time.Now()
+	store.lock.Lock()
+	for id, entry := range store.sessions {
+		if now.After(entry.expire) {
+			delete(store.sessions, id)
+		}
+	}
+	store.lock.Unlock()
+}
+
+func (store *MemorySessionStore) Close() { //停止后台清理
+	close(store.stop)
+}
+
+func (store *MemorySessionStore) Load(cookieValue string) (*Session, error) {
+	store.lock.Lock()
+	entry, ok := store.sessions[cookieValue]
+	store.lock.Unlock()
+	if !ok || time.Now().After(entry.expire) {
+		return nil, nil
+	}
+	session, _, err := decodeSession(entry.data)
+	return session, err
+}
+
+func (store *MemorySessionStore) Save(session *Session, expire time.Time) (string, error) {
+	data, err := encodeSession(session, expire)
+	if err != nil {
+		return "", err
+	}
+	store.lock.Lock()
+	if session.oldID != "" {
+		delete(store.sessions, session.oldID)
+	}
+	store.sessions[session.ID] = memorySessionEntry{data, expire}
+	store.lock.Unlock()
+	return session.ID, nil
+}
+
+func (store *MemorySessionStore) Delete(session *Session) error {
+	store.lock.Lock()
+	delete(store.sessions, session.ID)
+	if session.oldID != "" {
+		delete(store.sessions, session.oldID)
+	}
+	store.lock.Unlock()
+	return nil
+}
+
+type FileSessionStore struct { //文件会话存储，每个会话一个文件
+	dir  string
+	stop chan struct{}
+}
+
+func NewFileSessionStore(dir string, gcInterval time.Duration) (*FileSessionStore, error) { //创建文件会话存储，每gcInterval清理一次过期会话
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	store := &FileSessionStore{dir, make(chan struct{})}
+	if gcInterval > 0 {
+		go store.gcLoop(gcInterval)
+	}
+	return store, nil
+}
+
+func validSessionID(id string) bool { //只允许base64url字符，防止路径穿越
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func (store *FileSessionStore) sessionPath(id string) string {
+	return filepath.Join(store.dir, "sess_"+id)
+}
+
+func (store *FileSessionStore) gcLoop(gcInterval time.Duration) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			store.GC()
+		case <-store.stop:
+			return
+		}
+	}
+}
+
+func (store *FileSessionStore) GC() { //清理过期会话
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "sess_") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(store.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		_, expire, err := decodeSession(data)
+		if err != nil || now.After(expire) {
+			os.Remove(filepath.Join(store.dir, entry.Name()))
+		}
+	}
+}
+
+func (store *FileSessionStore) Close() { //停止后台清理
+	close(store.stop)
+}
+
+func (store *FileSessionStore) Load(cookieValue string) (*Session, error) {
+	if !validSessionID(cookieValue) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(store.sessionPath(cookieValue))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	session, expire, err := decodeSession(data)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expire) {
+		os.Remove(store.sessionPath(cookieValue))
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (store *FileSessionStore) Save(session *Session, expire time.Time) (string, error) {
+	data, err := encodeSession(session, expire)
+	if err != nil {
+		return "", err
+	}
+	tempFile, err := os.CreateTemp(store.dir, "tmp_")
+	if err != nil {
+		return "", err
+	}
+	_, err = tempFile.Write(data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), store.sessionPath(session.ID)) //先写临时文件再改名，避免读到写了一半的文件
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	if session.oldID != "" && validSessionID(session.oldID) {
+		os.Remove(store.sessionPath(session.oldID))
+	}
+	return session.ID, nil
+}
+
+func (store *FileSessionStore) Delete(session *Session) error {
+	if session.oldID != "" && validSessionID(session.oldID) {
+		os.Remove(store.sessionPath(session.oldID))
+	}
+	if !validSessionID(session.ID) {
+		return nil
+	}
+	err := os.Remove(store.sessionPath(session.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+type CookieSessionStore struct { //会话内容加密后全部存在cookie里，服务端不保存任何东西
+	keys *CookieKeys
+}
+
+func NewCookieSessionStore(keys *CookieKeys) *CookieSessionStore { //创建cookie会话存储
+	return &CookieSessionStore{keys}
+}
+
+func (store *CookieSessionStore) Load(cookieValue string) (*Session, error) {
+	data, err := decryptCookieValue(store.keys, "session", cookieValue)
+	if err != nil {
+		return nil, nil
+	}
+	session, _, err := decodeSession(data)
+	if err != nil {
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (store *CookieSessionStore) Save(session *Session, expire time.Time) (string, error) {
+	if len(store.keys.SigningKey) == 0 {
+		return "", ErrNoCookieKey
+	}
+	data, err := encodeSession(session, expire)
+	if err != nil {
+		return "", err
+	}
+	value, err := encryptCookieValue(store.keys.SigningKey, "session", data, expire.Unix())
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxSessionCookieSize {
+		return "", ErrSessionTooLarge
+	}
+	return value, nil
+}
+
+func (store *CookieSessionStore) Delete(session *Session) error {
+	return nil
+}
+
+type SessionConfig struct { //会话中间件的配置
+	Store           SessionStore
+	CookieName      string
+	CookiePath      string
+	CookieDomain    string
+	Secure          bool
+	SameSite        string
+	IdleTimeout     time.Duration //多久没访问就过期
+	AbsoluteTimeout time.Duration //从创建开始最多存活多久
+}
+
+func SessionMiddleware(config SessionConfig) Middleware { //会话中间件，处理函数里用request.GetSession()拿到会话
+	if config.Store == nil {
+		config.Store = NewMemorySessionStore(time.Minute)
+	}
+	if config.CookieName == "" {
+		config.CookieName = defaultSessionCookieName
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.SameSite == "" {
+		config.SameSite = SameSiteLax
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = defaultSessionIdleTimeout
+	}
+	if config.AbsoluteTimeout == 0 {
+		config.AbsoluteTimeout = defaultSessionAbsoluteTimeout
+	}
+	return func(next func(*Request) *Response) func(*Request) *Response {
+		return func(request *Request) *Response {
+			var session *Session
+			now := time.Now()
+			hadCookie := false
+			if cookieValue, ok := request.GetCookie(config.CookieName); ok && cookieValue != "" {
+				hadCookie = true
+				loadedSession, err := config.Store.Load(cookieValue)
+				if err == nil && loadedSession != nil {
+					if now.Sub(loadedSession.LastAccess) <= config.IdleTimeout && now.Sub(loadedSession.CreatedAt) <= config.AbsoluteTimeout {
+						session = loadedSession
+					} else {
+						config.Store.Delete(loadedSession)
+					}
+				}
+			}
+			if session == nil {
+				session = newSession()
+			}
+			request.session = session
+
+			response := next(request)
+
+			cookie := &Cookie{Name: config.CookieName, Path: config.CookiePath, Domain: config.CookieDomain, Secure: config.Secure, HttpOnly: true, SameSite: config.SameSite}
+			if session.destroyed {
+				if !session.isNew || session.oldID != "" {
+					if err := config.Store.Delete(session); err != nil {
+						panic(err)
+					}
+				}
+				if hadCookie && !response.sendedHeader {
+					response.DeleteCookie(cookie)
+				}
+				return response
+			}
+			if session.isNew && !session.modified { //没有往新会话里写东西就不发cookie
+				return response
+			}
+			session.LastAccess = now
+			expire := now.Add(config.IdleTimeout)
+			if absoluteExpire := session.CreatedAt.Add(config.AbsoluteTimeout); absoluteExpire.Before(expire) {
+				expire = absoluteExpire
+			}
+			value, err := config.Store.Save(session, expire)
+			if err != nil {
+				panic(err)
+			}
+			cookie.Value = value
+			if !response.sendedHeader {
+				response.SetCookie(cookie)
+			}
+			return response
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package simpwebserv
+
+import (
+	"testing"
+)
+
+func sessionTestHandler() func(*Request) *Response { //按路径操作会话，响应body是当前的count
+	return func(request *Request) *Response {
+		session := request.GetSession()
+		switch request.Path {
+		case "/set":
+			session.Set("count", "1")
+		case "/incr":
+			count, _ := session.Get("count")
+			session.Set("count", count+"1")
+		case "/login":
+			session.Regenerate()
+		case "/logout":
+			session.Destroy()
+		}
+		response := BuildBasicResponse()
+		count, _ := session.Get("count")
+		response.Body.WriteString(count)
+		return response
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	stores := map[string]SessionStore{
+		"memory": NewMemorySessionStore(0),
+		"cookie": NewCookieSessionStore(NewCookieKeys([]byte("session key"))),
+	}
+	for name, store := range stores {
+		handler := Chain(sessionTestHandler(), SessionMiddleware(SessionConfig{Store: store}))
+		send := func(path string, cookie string) *Response {
+			request := newTestRequest(t, "GET", path)
+			if cookie != "" {
+				request.Header.Set("Cookie", cookie)
+			}
+			return handler(request)
+		}
+
+		if response := send("/", ""); len(response.SetCookieList) != 0 {
+			t.Fatalf("%s: an untouched new session must not set a cookie", name)
+		}
+		cookie := cookieHeaderFrom(send("/set", ""))
+		if cookie == "" {
+			t.Fatalf("%s: writing to the session should set a cookie", name)
+		}
+		response := send("/incr", cookie)
+		if response.Body.String() != "11" {
+			t.Fatalf("%s: got %q, want the value saved by the previous request", name, response.Body.String())
+		}
+		cookie = cookieHeaderFrom(response)
+
+		response = send("/login", cookie)
+		loginCookie := cookieHeaderFrom(response)
+		if loginCookie == "" || loginCookie == cookie || response.Body.String() != "11" {
+			t.Fatalf("%s: regenerate should keep the values under a new cookie", name)
+		}
+		if name == "memory" && send("/", cookie).Body.String() != "" {
+			t.Fatalf("%s: the old session ID must stop working after regenerate", name)
+		}
+
+		response = send("/logout", loginCookie)
+		if len(response.SetCookieList) != 1 || cookieHeaderFrom(response) != defaultSessionCookieName+"=" {
+			t.Fatalf("%s: destroy should delete the cookie, got %v", name, response.SetCookieList)
+		}
+		if name == "memory" && send("/", loginCookie).Body.String() != "" {
+			t.Fatalf("%s: a destroyed session must not load again", name)
+		}
+		if send("/", defaultSessionCookieName+"=garbage").Body.String() != "" {
+			t.Fatalf("%s: an invalid cookie should start a new session", name)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package simpwebserv
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	shutdownPollInterval   = 50 * time.Millisecond
+)
+
+type connTracker struct { //记录所有连接，区分正在处理请求的和空闲的keep-alive连接
+	lock         sync.Mutex
+	conns        map[net.Conn]bool //true为正在处理请求
+	shuttingDown int32
+	done         chan struct{}
+	doneOnce     sync.Once
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]bool), done: make(chan struct{})}
+}
+
+func (tracker *connTracker) add(conn net.Conn) { //新连接先算作正在处理（TLS握手中）
+	tracker.lock.Lock()
+	tracker.conns[conn] = true
+	tracker.lock.Unlock()
+}
+
+func (tracker *connTracker) remove(conn net.Conn) {
+	tracker.lock.Lock()
+	delete(tracker.conns, conn)
+	tracker.lock.Unlock()
+}
+
+func (tracker *connTracker) setIdle(conn net.Conn) bool { //开始等下一个请求，正在退出时返回false
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if tracker.isShuttingDown() {
+		return false
+	}
+	tracker.conns[conn] = false
+	return true
+}
+
+func (tracker *connTracker) setActive(conn net.Conn) bool { //收到请求的第一个字节，连接已经被当作空闲连接关掉时返回false
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if _, ok := tracker.conns[conn]; !ok {
+		return false
+	}
+	tracker.conns[conn] = true
+	return true
+}
+
+func (tracker *connTracker) isShuttingDown() bool {
+	return atomic.LoadInt32(&tracker.shuttingDown) == 1
+}
+
+func (tracker *connTracker) closeIdle() int { //关掉空闲连接，返回还在处理请求的连接数
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	for conn, active := range tracker.conns {
+		if !active {
+			conn.Close()
+			delete(tracker.conns, conn)
+		}
+	}
+	return len(tracker.conns)
+}
+
+func (tracker *connTracker) closeAll() {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	for conn := range tracker.conns {
+		conn.Close()
+		delete(tracker.conns, conn)
+	}
+}
+
+func (tracker *connTracker) finish() {
+	tracker.doneOnce.Do(func() {
+		close(tracker.done)
+	})
+}
+
+func (tracker *connTracker) wait() { //正在退出时等到所有连接处理完
+	if tracker.isShuttingDown() {
+		<-tracker.done
+	}
+}
+
+func (app *AppStruct) SetShutdownTimeout(timeout time.Duration) { //设置平滑退出时等待请求处理完的最长时间，小于等于0为一直等
+	app.shutdownTimeout = timeout
+}
+
+func (app *AppStruct) Shutdown(timeout time.Duration) error { //平滑退出：停止接受新连接，关掉空闲的keep-alive连接，等正在处理的请求完成后Run返回，超时后强制关闭
+	atomic.StoreInt32(&app.connTracker.shuttingDown, 1)
+	app.listenerLock.Lock()
+	for i := 0; i < len(app.listeners); i++ {
+		app.listeners[i].Close()
+	}
+	app.listenerLock.Unlock()
+	if app.redirectApp != nil {
+		go app.redirectApp.Shutdown(timeout)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if app.connTracker.closeIdle() == 0 {
+			app.connTracker.finish()
+			return nil
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			app.cancelBaseContext()
+			app.connTracker.closeAll()
+			app.connTracker.finish()
+			if app.enableConsoleLog {
+				log.Println("Server shutdown timeout, closing remaining connections")
+			}
+			return ErrShutdownTimeout
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+}
@@ -2,8 +2,11 @@ package simpwebserv
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -11,32 +14,49 @@ type Response struct { //响应的结构体
 	Protocol      string
 	Code          string
 	CodeName      string
-	Header        map[string]string
+	Header        Header
 	Body          *bytes.Buffer
 	SetCookieList []string
 	sendedHeader  bool
 }
 
 type Request struct { //请求的结构体
-	conn             net.Conn
-	enableKeepAlive  bool
-	readRestData     []byte
-	keepAliveTimeout time.Duration
-	bodyReaded       uint64
-	Method           string
-	Path             string
-	UrlParameter     string
-	Protocol         string
-	Host             string
-	Header           map[string]string
-	bodyBytes        uint64
-	readedBytes      uint64
+	conn            net.Conn
+	readTimeout     time.Duration
+	readRestData    []byte
+	writeTimeout    time.Duration
+	bodyReaded      uint64
+	readTimedOut    bool
+	closeConn       bool //发完这个响应后关掉连接
+	bytesSent       uint64
+	route           string
+	requestID       string
+	minTransferRate int64
+	bodyReadStart   time.Time
+	Method          string
+	Path            string
+	UrlParameter    string
+	Protocol        string
+	Host            string
+	Header          Header
+	bodyBytes       uint64
+	session         *Session
+	form            map[string]string
+	csrfToken       string
+	csrfFieldName   string
+	User            string                 //认证中间件通过后的用户名
+	Claims          map[string]interface{} //JWT中间件通过后的claims
+	ctx             context.Context
+	cancel          context.CancelFunc
+	contextUsed     bool
+	watcher         *disconnectWatcher
 }
 
 type UrlNode struct { //单个path的节点
 	NextLayer   map[string]*UrlNode
 	IncludeBack bool
 	Function    func(*Request) *Response
+	pattern     string //注册时的路径，给监控指标用
 }
 
 type AppStruct struct { //实例的结构体
@@ -50,18 +70,85 @@ type AppStruct struct { //实例的结构体
 	enableConsoleLog           bool
 	enableKeepAlive            bool
 	multiThreadAcceptNum       uint16
-	keepAliveTimeout           time.Duration
+	idleTimeout                time.Duration
+	readHeaderTimeout          time.Duration
+	readTimeout                time.Duration
+	writeTimeout               time.Duration
+	middlewareList             []Middleware
+	handler                    func(*Request) *Response
+	tlsClientCAs               *x509.CertPool
+	tlsClientAuth              tls.ClientAuthType
+	tlsCertStore               *tlsCertStore
+	tlsReloadInterval          time.Duration
+	acmeManager                *acmeManager
+	hstsHeader                 string
+	listeners                  []net.Listener
+	listenerLock               sync.Mutex
+	connTracker                *connTracker
+	shutdownTimeout            time.Duration
+	redirectApp                *AppStruct
+	upgradeListeners           []namedListener //Run创建的监听，平滑升级时交给新进程
+	connLimiter                *connLimiter
+	minTransferRate            int64
+	maxHeaderCount             int
+	maxHeaderBytes             int
+	baseContext                context.Context //所有请求context的父context，平滑退出超时后取消
+	cancelBaseContext          context.CancelFunc
+	accessLogger               AccessLogger
+	metrics                    *serverMetrics
+	tracer                     *Tracer
+	health                     *healthRegistry
+	adminApp                   *AppStruct
 }
 
 type Config struct {
-	Host                 string
-	Port                 uint16
-	UseTls               bool
-	DebugMode            bool
-	DisableConsoleLog    bool
-	DisableKeepAlive     bool
-	KeepAliveTimeout     time.Duration
-	TlsPemPath           string
-	TlsKeyPath           string
-	MultiThreadAcceptNum uint16
+	Host                  string
+	Port                  uint16
+	UseTls                bool
+	DebugMode             bool
+	DisableConsoleLog     bool
+	DisableKeepAlive      bool
+	KeepAliveTimeout      time.Duration //keep-alive连接的超时时间，小于1秒的值按秒算（兼容旧配置，如30为30秒），也可以直接写30*time.Second；IdleTimeout是真正的Duration，两个都设置时以IdleTimeout为准
+	ReadHeaderTimeout     time.Duration //从收到请求的第一个字节到读完header的最长时间，默认30秒，小于0为不限制
+	ReadTimeout           time.Duration //读body时单次读取的最长等待时间，默认60秒，小于0为不限制
+	WriteTimeout          time.Duration //写响应时单次写入的最长等待时间，默认60秒，小于0为不限制
+	IdleTimeout           time.Duration //keep-alive连接等下一个请求的最长时间，默认60秒，小于0为不限制
+	TlsPemPath            string
+	TlsKeyPath            string
+	TlsClientCAPath       string               //签发客户端证书的CA，用于mTLS
+	TlsClientAuth         int                  //客户端证书模式，TlsClientAuthNone等
+	TlsCertificates       []TlsCertificatePair //额外的证书，按SNI选择
+	TlsReloadInterval     time.Duration        //检查证书文件变化的间隔，默认1分钟，小于0为不检查（仍可用SIGHUP触发）
+	AcmeDomains           []string             //不为空时用ACME自动申请证书
+	AcmeDirectoryURL      string               //默认为Let's Encrypt
+	AcmeEmail             string
+	AcmeCacheDir          string   //证书和账户密钥的缓存目录，默认为acme-cache
+	AcmeChallenges        []string //按偏好顺序使用的验证方式，默认为tls-alpn-01、http-01
+	AcmeCACertPath        string   //ACME服务器的CA证书，用于测试用的服务器（如Pebble）
+	RedirectHttpPort      uint16   //启用TLS时额外监听的明文端口（一般为80），把请求重定向到HTTPS
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	DevCert               bool     //用自动生成的本地CA签发开发用证书，不需要TlsPemPath和TlsKeyPath
+	DevCertDir            string   //开发用证书的缓存目录，默认为devcert
+	DevCertHosts          []string //除了localhost、127.0.0.1、::1和Host以外还要包含的主机名
+	MultiThreadAcceptNum  uint16
+	Listeners             []ListenConfig //额外的监听地址（IPv6、unix socket、明文和TLS同时监听等），Port为0时只用这些
+	GracefulRestart       bool           //收到SIGUSR2时启动新进程接管监听，收到SIGTERM时处理完当前请求再退出
+	ShutdownTimeout       time.Duration  //平滑退出时等待请求处理完的最长时间，默认30秒
+	MaxConnections        int            //最大同时连接数，超过的连接直接关掉，0为不限制
+	MaxConnectionsPerIP   int            //单个IP的最大同时连接数，0为不限制
+	MinTransferRate       int64          //读header和body的最低速率（字节每秒），防止慢速攻击，0为不限制
+	MaxHeaderCount        int            //header的最大数量，默认100，小于0为不限制
+	MaxHeaderBytes        int            //header的最大总大小，默认64KB，小于0为不限制
+	AccessLogPath         string         //访问日志文件，为空时不写
+	AccessLogFormat       int            //AccessLogFormatCommon等
+	AccessLogMaxSize      int64          //访问日志文件超过多大时轮转，默认100MB，小于0为不轮转
+	AccessLogMaxBackups   int            //轮转后保留几个旧文件，默认5
+	MetricsPath           string         //不为空时在这个路径上提供Prometheus监控指标，如/metrics
+	TracingExporter       string         //不为空时开启链路追踪，http://或https://开头为OTLP/HTTP的URL，stdout为标准输出，其他为文件路径
+	TracingServiceName    string         //默认为simpwebserv
+	TracingSampleRatio    float64        //没有上游trace时的采样比例，0为全部采样
+	HealthEndpoints       bool           //提供/healthz、/readyz和/livez，有管理端口（AdminListener或者继承的admin监听）时只在管理端口提供，MetricsPath也一样
+	AdminListener         ListenConfig   //Address不为空时单独监听一个明文的管理端口，提供HealthEndpoints的健康检查和MetricsPath的监控指标，平滑退出时等请求处理完才关闭
 }
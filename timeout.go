@@ -0,0 +1,76 @@
+package simpwebserv
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout = 30 * time.Second
+	defaultReadTimeout       = 60 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 60 * time.Second
+	closeDrainTimeout        = 500 * time.Millisecond
+	closeDrainMaxBytes       = 256 << 10
+)
+
+func timeoutDeadline(timeout time.Duration) time.Time { //小于等于0为不限制
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (app *AppStruct) SetTimeouts(readHeaderTimeout time.Duration, readTimeout time.Duration, writeTimeout time.Duration, idleTimeout time.Duration) { //设置各个超时，为0的保持不变，小于0为不限制
+	if readHeaderTimeout != 0 {
+		app.readHeaderTimeout = readHeaderTimeout
+	}
+	if readTimeout != 0 {
+		app.readTimeout = readTimeout
+	}
+	if writeTimeout != 0 {
+		app.writeTimeout = writeTimeout
+	}
+	if idleTimeout != 0 {
+		app.idleTimeout = idleTimeout
+	}
+}
+
+func (app *AppStruct) closeAfterReadError(request *Request, err error) { //读请求到一半出错，超时的话先回复408，header行太长回复431
+	if isTimeoutError(err) {
+		atomic.AddUint64(&app.connLimiter.stats.RequestTimeouts, 1)
+		sendErrorAndClose(request, Build408Response())
+		return
+	}
+	if err == ErrBufferTooBig {
+		atomic.AddUint64(&app.connLimiter.stats.HeaderTooLarge, 1)
+		sendErrorAndClose(request, Build431Response())
+		return
+	}
+	request.conn.Close()
+}
+
+func sendErrorAndClose(request *Request, response *Response) { //读请求时出错，回复后关掉连接
+	response.Header.Set("Content-Length", strconv.Itoa(response.Body.Len()))
+	request.SendHeader(response)
+	request.ConnWrite(response.Body.Bytes())
+	closeWriteAndDrain(request.conn)
+}
+
+func closeWriteAndDrain(conn net.Conn) { //先关掉写的一边，再丢掉对方已经发过来的数据，不然直接关掉会发RST，对方可能收不到响应
+	if closeWriter, ok := conn.(interface{ CloseWrite() error }); ok {
+		closeWriter.CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(closeDrainTimeout))
+		io.CopyN(io.Discard, conn, closeDrainMaxBytes)
+	}
+	conn.Close()
+}
@@ -0,0 +1,29 @@
+package simpwebserv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepAliveTimeoutConfig(t *testing.T) {
+	tests := []struct {
+		config Config
+		want   time.Duration
+	}{
+		{Config{}, defaultIdleTimeout},
+		{Config{KeepAliveTimeout: 30}, 30 * time.Second},
+		{Config{KeepAliveTimeout: 30 * time.Second}, 30 * time.Second},
+		{Config{KeepAliveTimeout: time.Second}, time.Second},
+		{Config{KeepAliveTimeout: -1}, -time.Second},
+		{Config{KeepAliveTimeout: 30, IdleTimeout: 5 * time.Second}, 5 * time.Second},
+	}
+	for _, test := range tests {
+		app := App()
+		if err := app.loadConfig(test.config); err != nil {
+			t.Fatal(err)
+		}
+		if app.idleTimeout != test.want {
+			t.Fatalf("KeepAliveTimeout %d: got %v, want %v", int64(test.config.KeepAliveTimeout), app.idleTimeout, test.want)
+		}
+	}
+}
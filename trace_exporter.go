@@ -0,0 +1,229 @@
+package simpwebserv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+	otlpExportTimeout   = 10 * time.Second
+)
+
+var spanKindNames = map[int]string{SpanKindInternal: "internal", SpanKindServer: "server", SpanKindClient: "client"}
+
+var spanStatusNames = map[int]string{SpanStatusUnset: "unset", SpanStatusOk: "ok", SpanStatusError: "error"}
+
+type jsonSpan struct {
+	Service       string                 `json:"service"`
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	Start         string                 `json:"start"`
+	DurationMs    float64                `json:"duration_ms"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Status        string                 `json:"status"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+type WriterSpanExporter struct { //每个span输出一行JSON，用于本地调试
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func NewWriterSpanExporter(writer io.Writer) *WriterSpanExporter { //writer为nil时输出到标准输出
+	if writer == nil {
+		writer = os.Stdout
+	}
+	return &WriterSpanExporter{writer: writer}
+}
+
+func (exporter *WriterSpanExporter) ExportSpans(spans []*SpanData) error {
+	var buffer bytes.Buffer
+	for _, span := range spans {
+		line := jsonSpan{
+			Service:       span.ServiceName,
+			Name:          span.Name,
+			Kind:          spanKindNames[span.Kind],
+			TraceID:       span.SpanContext.TraceID.String(),
+			SpanID:        span.SpanContext.SpanID.String(),
+			Start:         span.Start.Format(time.RFC3339Nano),
+			DurationMs:    float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Status:        spanStatusNames[span.StatusCode],
+			StatusMessage: span.StatusMessage,
+		}
+		if span.Parent.IsValid() {
+			line.ParentSpanID = span.Parent.SpanID.String()
+		}
+		if len(span.Attributes) != 0 {
+			line.Attributes = make(map[string]interface{}, len(span.Attributes))
+			for _, attribute := range span.Attributes {
+				line.Attributes[attribute.Key] = attribute.Value
+			}
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		buffer.Write(data)
+		buffer.WriteByte('\n')
+	}
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+	_, err := exporter.writer.Write(buffer.Bytes())
+	return err
+}
+
+func (exporter *WriterSpanExporter) Close() error { //writer是io.Closer的话关掉，标准输出和标准错误除外
+	if exporter.writer == os.Stdout || exporter.writer == os.Stderr {
+		return nil
+	}
+	if closer, ok := exporter.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type otlpAnyValue struct { //OTLP/JSON的AnyValue，只会有一个字段不为空
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` //int64在protobuf的JSON映射里是字符串
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Flags             uint32         `json:"flags,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpValue(value interface{}) otlpAnyValue {
+	switch v := value.(type) {
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	}
+	s := ""
+	return otlpAnyValue{StringValue: &s}
+}
+
+type OTLPHTTPExporter struct { //用OTLP/HTTP的JSON编码把span发给collector
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+func NewOTLPHTTPExporter(endpoint string, headers map[string]string) *OTLPHTTPExporter { //endpoint为完整的URL，为空时使用http://localhost:4318/v1/traces，headers用来放鉴权之类的header
+	if endpoint == "" {
+		endpoint = defaultOTLPEndpoint
+	}
+	return &OTLPHTTPExporter{endpoint, headers, &http.Client{Timeout: otlpExportTimeout}}
+}
+
+func (exporter *OTLPHTTPExporter) ExportSpans(spans []*SpanData) error {
+	services := make(map[string]int) //服务名在resourceSpans里的下标
+	request := otlpTraceRequest{}
+	for _, span := range spans {
+		index, ok := services[span.ServiceName]
+		if !ok {
+			index = len(request.ResourceSpans)
+			services[span.ServiceName] = index
+			resourceSpans := otlpResourceSpans{ScopeSpans: make([]otlpScopeSpans, 1)}
+			resourceSpans.Resource.Attributes = []otlpKeyValue{{"service.name", otlpValue(span.ServiceName)}}
+			resourceSpans.ScopeSpans[0].Scope.Name = "simpwebserv"
+			request.ResourceSpans = append(request.ResourceSpans, resourceSpans)
+		}
+		converted := otlpSpan{
+			TraceID:           span.SpanContext.TraceID.String(),
+			SpanID:            span.SpanContext.SpanID.String(),
+			TraceState:        span.SpanContext.TraceState,
+			Flags:             uint32(span.SpanContext.TraceFlags),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{span.StatusCode, span.StatusMessage},
+		}
+		if span.Parent.IsValid() {
+			converted.ParentSpanID = span.Parent.SpanID.String()
+		}
+		for _, attribute := range span.Attributes {
+			converted.Attributes = append(converted.Attributes, otlpKeyValue{attribute.Key, otlpValue(attribute.Value)})
+		}
+		scopeSpans := &request.ResourceSpans[index].ScopeSpans[0]
+		scopeSpans.Spans = append(scopeSpans.Spans, converted)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequest("POST", exporter.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	for key, value := range exporter.headers {
+		httpRequest.Header.Set(key, value)
+	}
+	httpResponse, err := exporter.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(httpResponse.Body, 64<<10))
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 {
+		return errors.New("otlp collector returned " + httpResponse.Status)
+	}
+	return nil
+}
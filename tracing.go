@@ -0,0 +1,467 @@
+package simpwebserv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SpanKindInternal = iota + 1 //和OTLP的SpanKind取值一致
+	SpanKindServer
+	SpanKindClient
+)
+
+const (
+	SpanStatusUnset = iota
+	SpanStatusOk
+	SpanStatusError
+)
+
+const (
+	defaultTraceBatchSize    = 512
+	defaultTraceBatchTimeout = 5 * time.Second
+	defaultTraceQueueSize    = 2048
+	traceFlagSampled         = 0x01
+	maxTraceStateLength      = 512
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type SpanContext struct { //W3C Trace Context里传递的内容
+	TraceID    TraceID
+	SpanID     SpanID
+	TraceFlags byte
+	TraceState string //原样转发的tracestate
+	Remote     bool   //是不是从请求header里解析出来的
+}
+
+func (spanContext SpanContext) IsValid() bool {
+	return spanContext.TraceID.IsValid() && spanContext.SpanID.IsValid()
+}
+
+func (spanContext SpanContext) Sampled() bool {
+	return spanContext.TraceFlags&traceFlagSampled != 0
+}
+
+func (spanContext SpanContext) Traceparent() string { //生成traceparent header的值
+	return "00-" + spanContext.TraceID.String() + "-" + spanContext.SpanID.String() + "-" + hex.EncodeToString([]byte{spanContext.TraceFlags})
+}
+
+func isLowerHex(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if !(value[i] >= '0' && value[i] <= '9') && !(value[i] >= 'a' && value[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func ParseTraceparent(value string) (SpanContext, bool) { //解析traceparent header，格式不对或者ID全为0时返回false
+	spanContext := SpanContext{Remote: true}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext, false
+	}
+	if !isLowerHex(parts[0]) || !isLowerHex(parts[1]) || !isLowerHex(parts[2]) || !isLowerHex(parts[3]) {
+		return spanContext, false
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) { //ff是非法版本，更高的版本只看前4段
+		return spanContext, false
+	}
+	hex.Decode(spanContext.TraceID[:], []byte(parts[1]))
+	hex.Decode(spanContext.SpanID[:], []byte(parts[2]))
+	flags, _ := hex.DecodeString(parts[3])
+	spanContext.TraceFlags = flags[0]
+	return spanContext, spanContext.IsValid()
+}
+
+func InjectTraceContext(ctx context.Context, set func(key string, value string)) { //把ctx里的span写到发出去的请求header，set可以是http.Header.Set
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	set("traceparent", span.spanContext.Traceparent())
+	if span.spanContext.TraceState != "" {
+		set("tracestate", span.spanContext.TraceState)
+	}
+}
+
+type SpanAttribute struct { //Value为string、int64、float64或bool
+	Key   string
+	Value interface{}
+}
+
+type SpanData struct { //结束了的span，交给SpanExporter导出
+	ServiceName   string
+	Name          string
+	Kind          int
+	SpanContext   SpanContext
+	Parent        SpanContext //没有父span时无效
+	Start         time.Time
+	End           time.Time
+	Attributes    []SpanAttribute
+	StatusCode    int
+	StatusMessage string
+}
+
+type Span struct { //一个进行中的span，nil的Span上的方法什么都不做
+	lock        sync.Mutex
+	tracer      *Tracer
+	spanContext SpanContext
+	data        SpanData
+	recording   bool //没有被采样的span只用来传递trace id
+	ended       bool
+}
+
+func (span *Span) SpanContext() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.spanContext
+}
+
+func (span *Span) IsRecording() bool {
+	return span != nil && span.recording
+}
+
+func normalizeSpanAttribute(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string, int64, float64, bool:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case time.Duration:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (span *Span) SetAttribute(key string, value interface{}) { //设置属性，已有同名属性时覆盖
+	if !span.IsRecording() {
+		return
+	}
+	value = normalizeSpanAttribute(value)
+	span.lock.Lock()
+	defer span.lock.Unlock()
+	for i := 0; i < len(span.data.Attributes); i++ {
+		if span.data.Attributes[i].Key == key {
+			span.data.Attributes[i].Value = value
+			return
+		}
+	}
+	span.data.Attributes = append(span.data.Attributes, SpanAttribute{key, value})
+}
+
+func (span *Span) SetName(name string) {
+	if !span.IsRecording() {
+		return
+	}
+	span.lock.Lock()
+	span.data.Name = name
+	span.lock.Unlock()
+}
+
+func (span *Span) SetStatus(code int, message string) { //设置状态，message只在SpanStatusError时有意义
+	if !span.IsRecording() {
+		return
+	}
+	span.lock.Lock()
+	span.data.StatusCode = code
+	span.data.StatusMessage = message
+	span.lock.Unlock()
+}
+
+func (span *Span) End() { //结束span并交给tracer导出，重复调用只有第一次有效
+	if !span.IsRecording() {
+		return
+	}
+	span.lock.Lock()
+	if span.ended {
+		span.lock.Unlock()
+		return
+	}
+	span.ended = true
+	span.data.End = time.Now()
+	data := span.data
+	span.lock.Unlock()
+	span.tracer.enqueue(&data)
+}
+
+type spanContextKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+func SpanFromContext(ctx context.Context) *Span { //没有span时返回nil
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+func (request *Request) Span() *Span { //这个请求的server span，没有设置tracer时返回nil
+	if request.ctx == nil {
+		return nil
+	}
+	return SpanFromContext(request.ctx)
+}
+
+type SpanExporter interface { //span的导出方式，在tracer的后台goroutine里调用
+	ExportSpans(spans []*SpanData) error
+}
+
+type TracerConfig struct {
+	ServiceName  string
+	Exporter     SpanExporter
+	SampleRatio  float64       //没有上游trace时的采样比例，0为全部采样，有上游时跟随上游的采样标志
+	BatchSize    int           //攒够多少个span导出一次，默认512
+	BatchTimeout time.Duration //最多隔多久导出一次，默认5秒
+	QueueSize    int           //等待导出的队列长度，满了会丢掉span，默认2048
+}
+
+type Tracer struct { //创建span并在后台批量导出
+	config  TracerConfig
+	spans   chan *SpanData
+	lock    sync.RWMutex
+	closed  bool
+	dropped uint64
+	done    chan struct{}
+}
+
+func NewTracer(config TracerConfig) *Tracer {
+	if config.ServiceName == "" {
+		config.ServiceName = "simpwebserv"
+	}
+	if config.SampleRatio <= 0 || config.SampleRatio > 1 {
+		config.SampleRatio = 1
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultTraceBatchSize
+	}
+	if config.BatchTimeout <= 0 {
+		config.BatchTimeout = defaultTraceBatchTimeout
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultTraceQueueSize
+	}
+	tracer := &Tracer{config: config, spans: make(chan *SpanData, config.QueueSize), done: make(chan struct{})}
+	go tracer.exportLoop()
+	return tracer
+}
+
+func randomTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func randomSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func (tracer *Tracer) shouldSample(traceID TraceID) bool { //按trace id的后8个字节采样，同一个trace在各个服务的结果一样
+	if tracer.config.SampleRatio >= 1 {
+		return true
+	}
+	return binary.BigEndian.Uint64(traceID[8:])>>11 < uint64(tracer.config.SampleRatio*(1<<53))
+}
+
+func (tracer *Tracer) newSpan(parent SpanContext, name string, kind int, start time.Time) *Span {
+	span := &Span{tracer: tracer}
+	if parent.IsValid() {
+		span.spanContext = SpanContext{parent.TraceID, randomSpanID(), parent.TraceFlags, parent.TraceState, false}
+	} else {
+		span.spanContext = SpanContext{TraceID: randomTraceID(), SpanID: randomSpanID()}
+		if tracer.shouldSample(span.spanContext.TraceID) {
+			span.spanContext.TraceFlags = traceFlagSampled
+		}
+	}
+	span.recording = span.spanContext.Sampled()
+	if span.recording {
+		span.data = SpanData{ServiceName: tracer.config.ServiceName, Name: name, Kind: kind, SpanContext: span.spanContext, Parent: parent, Start: start}
+	}
+	return span
+}
+
+func (tracer *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) { //在ctx里的span下面创建子span，用完要调用End
+	return tracer.StartWithKind(ctx, name, SpanKindInternal)
+}
+
+func (tracer *Tracer) StartWithKind(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	span := tracer.newSpan(SpanFromContext(ctx).SpanContext(), name, kind, time.Now())
+	return ContextWithSpan(ctx, span), span
+}
+
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) { //用ctx里的span所属的tracer创建子span，ctx里没有span时返回nil
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name)
+}
+
+func (tracer *Tracer) enqueue(data *SpanData) {
+	tracer.lock.RLock()
+	defer tracer.lock.RUnlock()
+	if tracer.closed {
+		return
+	}
+	select {
+	case tracer.spans <- data:
+	default:
+		atomic.AddUint64(&tracer.dropped, 1)
+	}
+}
+
+func (tracer *Tracer) exportLoop() {
+	defer close(tracer.done)
+	batch := make([]*SpanData, 0, tracer.config.BatchSize)
+	timer := time.NewTimer(tracer.config.BatchTimeout)
+	defer timer.Stop()
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := tracer.config.Exporter.ExportSpans(batch); err != nil {
+			log.Println("Trace export error: " + err.Error())
+		}
+		batch = make([]*SpanData, 0, tracer.config.BatchSize)
+	}
+	for {
+		select {
+		case data, ok := <-tracer.spans:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= tracer.config.BatchSize {
+				export()
+			}
+		case <-timer.C:
+			export()
+			timer.Reset(tracer.config.BatchTimeout)
+		}
+	}
+}
+
+func (tracer *Tracer) Dropped() uint64 { //队列满了被丢掉的span个数
+	return atomic.LoadUint64(&tracer.dropped)
+}
+
+func (tracer *Tracer) Close() error { //导出队列里剩下的span，exporter是io.Closer的话也会关掉
+	tracer.lock.Lock()
+	if tracer.closed {
+		tracer.lock.Unlock()
+		return nil
+	}
+	tracer.closed = true
+	close(tracer.spans)
+	tracer.lock.Unlock()
+	<-tracer.done
+	if closer, ok := tracer.config.Exporter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (app *AppStruct) SetTracer(tracer *Tracer) { //为每个请求创建server span，为nil时关闭
+	app.tracer = tracer
+}
+
+func (app *AppStruct) startServerSpan(request *Request, start time.Time) *Span {
+	parent, _ := ParseTraceparent(request.Header.Get("traceparent"))
+	if parent.IsValid() {
+		if traceState := request.Header.Get("tracestate"); len(traceState) <= maxTraceStateLength {
+			parent.TraceState = traceState
+		}
+	}
+	span := app.tracer.newSpan(parent, request.Method, SpanKindServer, start)
+	request.ctx = ContextWithSpan(request.ctx, span)
+	if !span.recording {
+		return span
+	}
+	scheme := "http"
+	if request.IsTls() {
+		scheme = "https"
+	}
+	span.SetAttribute("http.request.method", request.Method)
+	span.SetAttribute("url.path", request.Path)
+	if request.UrlParameter != "" {
+		span.SetAttribute("url.query", request.UrlParameter)
+	}
+	span.SetAttribute("url.scheme", scheme)
+	span.SetAttribute("network.protocol.version", strings.TrimPrefix(request.Protocol, "HTTP/"))
+	if host := request.Header.Get("Host"); host != "" {
+		span.SetAttribute("server.address", host)
+	}
+	if ip := request.RemoteIP(); ip != "" {
+		span.SetAttribute("client.address", ip)
+	}
+	if userAgent := request.Header.Get("User-Agent"); userAgent != "" {
+		span.SetAttribute("user_agent.original", userAgent)
+	}
+	return span
+}
+
+func endServerSpan(span *Span, request *Request, response *Response) {
+	if !span.IsRecording() {
+		return
+	}
+	if request.route != "" {
+		span.SetName(request.Method + " " + request.route)
+		span.SetAttribute("http.route", request.route)
+	}
+	code, _ := strconv.Atoi(response.Code)
+	span.SetAttribute("http.response.status_code", code)
+	span.SetAttribute("http.response.body.size", request.bytesSent)
+	if code >= 500 { //服务端span只有5xx算错误
+		span.SetStatus(SpanStatusError, response.CodeName)
+	}
+	span.End()
+}
@@ -0,0 +1,218 @@
+package simpwebserv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+type recordingSpanExporter struct { //把导出的span存起来给测试检查
+	lock  sync.Mutex
+	spans []*SpanData
+}
+
+func (exporter *recordingSpanExporter) ExportSpans(spans []*SpanData) error {
+	exporter.lock.Lock()
+	exporter.spans = append(exporter.spans, spans...)
+	exporter.lock.Unlock()
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	spanContext, ok := ParseTraceparent(" " + testTraceparent + " ")
+	if !ok || !spanContext.Remote || !spanContext.Sampled() {
+		t.Fatalf("got %+v, %v", spanContext, ok)
+	}
+	if spanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || spanContext.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("got trace %s span %s", spanContext.TraceID, spanContext.SpanID)
+	}
+	if spanContext.Traceparent() != testTraceparent {
+		t.Fatalf("round trip: got %s", spanContext.Traceparent())
+	}
+	if spanContext, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"); !ok || spanContext.Sampled() {
+		t.Errorf("unsampled: got %+v, %v", spanContext, ok)
+	}
+	if _, ok := ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future"); !ok {
+		t.Error("a future version may have extra fields")
+	}
+
+	invalid := map[string]string{
+		"empty":            "",
+		"uppercase":        "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01",
+		"zero trace id":    "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"zero span id":     "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"version ff":       "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"version 00 extra": testTraceparent + "-extra",
+		"short trace id":   "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"long span id":     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b70-01",
+		"short flags":      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"not hex":          "00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"missing flags":    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"wrong separators": "00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+		"signed flags":     "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-+1",
+	}
+	for name, value := range invalid {
+		if spanContext, ok := ParseTraceparent(value); ok {
+			t.Errorf("%s: %q parsed as %+v", name, value, spanContext)
+		}
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	always := &Tracer{config: TracerConfig{SampleRatio: 1}}
+	never := &Tracer{config: TracerConfig{SampleRatio: 1e-18}}
+	half := &Tracer{config: TracerConfig{SampleRatio: 0.5}}
+	low, high := TraceID{}, TraceID{}
+	for i := 8; i < 16; i++ {
+		high[i] = 0xff
+	}
+	if !always.shouldSample(high) || never.shouldSample(high) {
+		t.Fatal("ratio 1 should sample everything and a tiny ratio almost nothing")
+	}
+	if !half.shouldSample(low) || half.shouldSample(high) {
+		t.Fatal("sampling should be decided by the low 8 bytes of the trace id")
+	}
+
+	tracer := NewTracer(TracerConfig{Exporter: &recordingSpanExporter{}, SampleRatio: 1e-18})
+	defer tracer.Close()
+	parent, _ := ParseTraceparent(testTraceparent)
+	if span := tracer.newSpan(parent, "child", SpanKindServer, time.Now()); !span.IsRecording() || span.SpanContext().TraceID != parent.TraceID {
+		t.Error("a sampled upstream trace should be followed regardless of the ratio")
+	}
+	parent.TraceFlags = 0
+	if span := tracer.newSpan(parent, "child", SpanKindServer, time.Now()); span.IsRecording() || span.SpanContext().TraceID != parent.TraceID {
+		t.Error("an unsampled upstream trace should still propagate its trace id")
+	}
+}
+
+func TestTracerSpans(t *testing.T) {
+	exporter := &recordingSpanExporter{}
+	tracer := NewTracer(TracerConfig{ServiceName: "test", Exporter: exporter})
+	ctx, root := tracer.StartWithKind(context.Background(), "root", SpanKindServer)
+	childCtx, child := StartSpan(ctx, "child")
+	child.SetAttribute("count", 3)
+	child.SetAttribute("count", 4)
+	child.SetStatus(SpanStatusError, "boom")
+	child.End()
+	child.End()
+	root.End()
+
+	headers := make(map[string]string)
+	InjectTraceContext(childCtx, func(key string, value string) { headers[key] = value })
+	if headers["traceparent"] != child.SpanContext().Traceparent() {
+		t.Errorf("injected %v", headers)
+	}
+	if _, span := StartSpan(context.Background(), "orphan"); span != nil {
+		t.Error("StartSpan without a parent should return nil")
+	}
+	var nilSpan *Span
+	nilSpan.SetAttribute("ignored", true)
+	nilSpan.End()
+
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(exporter.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(exporter.spans))
+	}
+	childData, rootData := exporter.spans[0], exporter.spans[1]
+	if childData.Parent.SpanID != rootData.SpanContext.SpanID || childData.SpanContext.TraceID != rootData.SpanContext.TraceID || rootData.Parent.IsValid() {
+		t.Error("child span should be parented to the root span")
+	}
+	if len(childData.Attributes) != 1 || childData.Attributes[0].Value != int64(4) || childData.StatusMessage != "boom" || childData.ServiceName != "test" {
+		t.Errorf("got %+v", childData)
+	}
+}
+
+func TestServerSpan(t *testing.T) {
+	exporter := &recordingSpanExporter{}
+	app := App()
+	app.SetTracer(NewTracer(TracerConfig{Exporter: exporter}))
+	request := newTestRequest(t, "GET", "/users/42")
+	request.ctx = context.Background()
+	request.route = "/users/*"
+	request.Header.Set("traceparent", testTraceparent)
+	request.Header.Set("tracestate", "vendor=value")
+	span := app.startServerSpan(request, time.Now())
+	if request.Span() != span || span.SpanContext().TraceState != "vendor=value" {
+		t.Fatalf("got %+v", span.SpanContext())
+	}
+	response := BuildBasicResponse()
+	response.Code = "503"
+	endServerSpan(span, request, response)
+	app.tracer.Close()
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(exporter.spans))
+	}
+	data := exporter.spans[0]
+	if data.Name != "GET /users/*" || data.Kind != SpanKindServer || data.StatusCode != SpanStatusError || data.Parent.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("got %+v", data)
+	}
+}
+
+func TestWriterSpanExporter(t *testing.T) {
+	var buffer bytes.Buffer
+	parent, _ := ParseTraceparent(testTraceparent)
+	start := time.Now()
+	span := &SpanData{ServiceName: "test", Name: "GET /", Kind: SpanKindServer, SpanContext: SpanContext{TraceID: parent.TraceID, SpanID: randomSpanID()}, Parent: parent, Start: start, End: start.Add(1500 * time.Microsecond), Attributes: []SpanAttribute{{"http.response.status_code", int64(200)}}}
+	if err := NewWriterSpanExporter(&buffer).ExportSpans([]*SpanData{span, span}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines", len(lines))
+	}
+	var line jsonSpan
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Kind != "server" || line.ParentSpanID != "00f067aa0ba902b7" || line.DurationMs != 1.5 || line.Status != "unset" || line.Attributes["http.response.status_code"] != float64(200) {
+		t.Errorf("got %+v", line)
+	}
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	var received otlpTraceRequest
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		if len(received.ResourceSpans) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPHTTPExporter(server.URL, map[string]string{"Authorization": "Bearer secret"})
+	start := time.Now()
+	spans := []*SpanData{
+		{ServiceName: "a", Name: "one", Kind: SpanKindServer, SpanContext: SpanContext{TraceID: randomTraceID(), SpanID: randomSpanID(), TraceFlags: traceFlagSampled}, Start: start, End: start, Attributes: []SpanAttribute{{"ok", true}}},
+		{ServiceName: "b", Name: "two", Kind: SpanKindInternal, SpanContext: SpanContext{TraceID: randomTraceID(), SpanID: randomSpanID()}, Start: start, End: start},
+		{ServiceName: "a", Name: "three", Kind: SpanKindClient, SpanContext: SpanContext{TraceID: randomTraceID(), SpanID: randomSpanID()}, Start: start, End: start},
+	}
+	if err := exporter.ExportSpans(spans); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("got Authorization %q", authorization)
+	}
+	if len(received.ResourceSpans) != 2 || len(received.ResourceSpans[0].ScopeSpans[0].Spans) != 2 || len(received.ResourceSpans[1].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("spans should be grouped by service, got %+v", received)
+	}
+	first := received.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if first.Name != "one" || first.TraceID != spans[0].SpanContext.TraceID.String() || first.Flags != 1 || *first.Attributes[0].Value.BoolValue != true {
+		t.Errorf("got %+v", first)
+	}
+	if err := exporter.ExportSpans(nil); err == nil {
+		t.Error("a non-2xx response should be an error")
+	}
+}
@@ -0,0 +1,111 @@
+//go:build !windows
+// +build !windows
+
+package simpwebserv
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const upgradeReadyTimeout = 30 * time.Second
+
+func (app *AppStruct) handleRestartSignals() { //SIGUSR2平滑升级，SIGTERM平滑退出
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGUSR2, syscall.SIGTERM)
+	for sig := range signalChan {
+		if sig == syscall.SIGUSR2 {
+			if err := app.Upgrade(); err != nil {
+				log.Println("Server upgrade error: " + err.Error())
+				continue
+			}
+			if app.enableConsoleLog {
+				log.Println("Server upgraded, draining connections")
+			}
+		} else if app.enableConsoleLog {
+			log.Println("Server is shutting down")
+		}
+		signal.Stop(signalChan)
+		app.Shutdown(app.shutdownTimeout)
+		return
+	}
+}
+
+func (app *AppStruct) Upgrade() error { //重新运行当前程序并把Run创建的监听交给它，新进程开始监听后返回，之后应调用Shutdown让旧进程退出
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	executable = strings.TrimSuffix(executable, " (deleted)") //程序文件已经被新版本替换
+
+	files := make([]*os.File, 0, len(app.upgradeListeners)+1)
+	names := make([]string, 0, len(app.upgradeListeners))
+	defer func() {
+		for i := 0; i < len(files); i++ {
+			files[i].Close()
+		}
+	}()
+	for i := 0; i < len(app.upgradeListeners); i++ {
+		fileListener, ok := app.upgradeListeners[i].listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		file, err := fileListener.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		names = append(names, app.upgradeListeners[i].name)
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	files = append(files, readyWriter)
+
+	env := make([]string, 0, len(os.Environ())+5)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") && !strings.HasPrefix(kv, "SIMPWEBSERV_UPGRADE_") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		"LISTEN_FDS="+strconv.Itoa(len(names)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		upgradeParentEnv+"="+strconv.Itoa(os.Getpid()),
+		upgradeReadyFdEnv+"="+strconv.Itoa(listenFdsStart+len(names)),
+	)
+
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...),
+	})
+	if err != nil {
+		return err
+	}
+	readyWriter.Close() //只留子进程的写端，子进程异常退出时读端会返回EOF
+	files = files[:len(files)-1]
+
+	readyReader.SetReadDeadline(time.Now().Add(upgradeReadyTimeout))
+	if _, err = readyReader.Read(make([]byte, 1)); err != nil {
+		process.Kill()
+		process.Wait()
+		return ErrUpgradeFailed
+	}
+	process.Release()
+
+	for i := 0; i < len(app.upgradeListeners); i++ { //socket文件已经归新进程了，关闭监听时不要删掉
+		if unixListener, ok := app.upgradeListeners[i].listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package simpwebserv
+
+import (
+	"log"
+	"os"
+	"os/signal"
+)
+
+func (app *AppStruct) handleRestartSignals() { //Windows没有SIGUSR2，只支持Ctrl+C平滑退出
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	<-signalChan
+	signal.Stop(signalChan)
+	if app.enableConsoleLog {
+		log.Println("Server is shutting down")
+	}
+	app.Shutdown(app.shutdownTimeout)
+}
+
+func (app *AppStruct) Upgrade() error { //Windows不能把监听传给子进程
+	return ErrUpgradeNotSupported
+}